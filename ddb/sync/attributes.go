@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Lock items are stored in the table with these attributes, among others:
+//
+//	Name       S  the name of the lock, the hash key of the table, see KeyAttributeName
+//	LockerID   N  random int64 identifying the Mutex holding the lock, 0 if the lock is free, see LockerIDAttributeName
+//	OwnerID    S  identifier of the holder of the lock, or of the last holder if the lock is free, see OwnerID
+//	LastWrite  N  Unix time in nanoseconds of the last lock, unlock or refresh, see ParseLastWrite and LastWriteAttributeName
+//	Fence      N  fencing token, incremented on every acquisition
+//	Value      S  the value of the Mutex, written on unlock, see ValueAttributeName
+//	Bytes      B  the binary value of the Mutex, written on unlock, see SetValueBytes
+//	ExpiresAt  N  Unix time in seconds at which DynamoDB may delete the item, see EnableTTL
+//	NextTicket, ServingTicket  N  the last ticket taken and the last ticket admitted by Fair locks
+
+// ownerAttribute holds the OwnerID of the holder of a lock.
+const ownerAttribute = "OwnerID"
+
+// maxKeyAttributeNameLength is the longest name DynamoDB allows for a key attribute, in bytes.
+const maxKeyAttributeNameLength = 255
+
+// itemAttributes holds the attributes written to lock items, which cannot be used as the key attribute.
+var itemAttributes = map[string]bool{
+	"Bytes":             true,
+	"Checksum":          true,
+	"CooldownUntil":     true,
+	"Counter":           true,
+	"Done":              true,
+	"Encoding":          true,
+	"ExpiresAt":         true,
+	"FailedAttempts":    true,
+	"Fence":             true,
+	"Holders":           true,
+	"LastWrite":         true,
+	"LockerID":          true,
+	"NextTicket":        true,
+	"NotBefore":         true,
+	"OwnerID":           true,
+	"PermissionProbe":   true,
+	"PreviousLastWrite": true,
+	"PreviousLockerID":  true,
+	"Readers":           true,
+	"ReleasedBy":        true,
+	"RequestID":         true,
+	"ServingTicket":     true,
+	"Retired":           true,
+	"Value":             true,
+	"WaitingWriter":     true,
+	"Waiters":           true,
+	"Writer":            true,
+}
+
+// validAttributeName matches the attribute names allowed for the configurable attributes of lock items. DynamoDB
+// allows more, but these never need escaping in tools that read the table.
+var validAttributeName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,255}$`)
+
+// configureAttributeNames applies the default names of the value, LastWrite and LockerID attributes, and checks that
+// the names are valid and distinct from each other and from the other attributes of lock items.
+func (m *Mutex) configureAttributeNames() error {
+	used := map[string]bool{m.KeyAttributeName: true}
+	for _, attribute := range []struct {
+		kind, defaultName string
+		name              *string
+	}{
+		{"value", "Value", &m.ValueAttributeName},
+		{"last write", "LastWrite", &m.LastWriteAttributeName},
+		{"locker ID", "LockerID", &m.LockerIDAttributeName},
+	} {
+		if *attribute.name == "" {
+			*attribute.name = attribute.defaultName
+		}
+		name := *attribute.name
+		if !validAttributeName.MatchString(name) || used[name] || (name != attribute.defaultName && itemAttributes[name]) {
+			return fmt.Errorf("invalid %s attribute name %q: use 1-255 characters of a-z, A-Z, 0-9, '_', '-' and '.', other than the other attributes of a lock item", attribute.kind, name)
+		}
+		used[name] = true
+	}
+	return nil
+}
+
+// ParseLastWrite reads the LastWrite attribute of a lock item, as written by the Mutex.
+func ParseLastWrite(av *dynamodb.AttributeValue) (time.Time, error) {
+	if av == nil || av.N == nil {
+		return time.Time{}, errors.New("LastWrite is not a number")
+	}
+	nanoseconds, err := strconv.ParseInt(*av.N, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanoseconds), nil
+}
+
+// FormatLastWrite returns the LastWrite attribute of a lock item written at t, in the format used by the Mutex.
+func FormatLastWrite(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(t.UnixNano(), 10)),
+	}
+}
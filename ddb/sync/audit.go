@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// An AuditEntry records a failed attempt to lock a Mutex.
+type AuditEntry struct {
+	// LockerID of the Mutex that tried to lock.
+	LockerID int64
+	// Time of the attempt, according to the clock of the Mutex that tried to lock.
+	Time time.Time
+}
+
+// recordFailedAttempt appends an AuditEntry for a failed attempt to lock the Mutex to its lock item, keeping at most
+// AuditFailedAttempts entries. Failures are only logged, as the audit trail is informational.
+func (m *Mutex) recordFailedAttempt() {
+	if m.AuditFailedAttempts <= 0 {
+		return
+	}
+	names := map[string]*string{
+		"#name":   aws.String(m.KeyAttributeName),
+		"#failed": aws.String("FailedAttempts"),
+	}
+	key := m.key(m.Name)
+	max := &dynamodb.AttributeValue{
+		N: aws.String(strconv.Itoa(m.AuditFailedAttempts)),
+	}
+	appendInput := &dynamodb.UpdateItemInput{
+		ConditionExpression:      aws.String("attribute_exists(#name) AND ( attribute_not_exists(#failed) OR size(#failed) < :max )"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":max": max,
+			":empty": {
+				L: []*dynamodb.AttributeValue{},
+			},
+			":entry": {
+				L: []*dynamodb.AttributeValue{
+					{
+						M: map[string]*dynamodb.AttributeValue{
+							"LockerID": {
+								N: aws.String(strconv.FormatInt(m.id, 10)),
+							},
+							"Time": {
+								N: aws.String(strconv.FormatInt(m.clock().Now().UnixNano(), 10)),
+							},
+						},
+					},
+				},
+			},
+		},
+		Key:              key,
+		UpdateExpression: aws.String("SET #failed = list_append(if_not_exists(#failed, :empty), :entry)"),
+		TableName:        &m.DDBTableName,
+	}
+	_, err := m.DDBSession.UpdateItem(appendInput)
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		// The trail is full: drop the oldest entry to make room
+		_, err = m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+			ConditionExpression: aws.String("size(#failed) >= :max"),
+			ExpressionAttributeNames: map[string]*string{
+				"#failed": aws.String("FailedAttempts"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":max": max,
+			},
+			Key:              key,
+			UpdateExpression: aws.String("REMOVE #failed[0]"),
+			TableName:        &m.DDBTableName,
+		})
+		if err == nil || isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			_, err = m.DDBSession.UpdateItem(appendInput)
+		}
+	}
+	if err != nil {
+		m.logf("could not record failed attempt to lock %s: %v", m.Name, err)
+	}
+}
+
+// FailedAttempts returns the failed attempts to lock the Mutex recorded in its lock item, oldest first.
+// Attempts are only recorded by instances with AuditFailedAttempts set.
+func (m *Mutex) FailedAttempts() (entries []AuditEntry, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	item, err := m.readItem(m.Name)
+	if err != nil || item == nil || item["FailedAttempts"] == nil {
+		return
+	}
+	for _, value := range item["FailedAttempts"].L {
+		var entry AuditEntry
+		if id := value.M["LockerID"]; id != nil && id.N != nil {
+			entry.LockerID, err = strconv.ParseInt(*id.N, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if at := value.M["Time"]; at != nil && at.N != nil {
+			nanoseconds, err := strconv.ParseInt(*at.N, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			entry.Time = time.Unix(0, nanoseconds)
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
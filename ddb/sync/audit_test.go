@@ -0,0 +1,30 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_FailedAttempts(t *testing.T) {
+	TableName := fmt.Sprintf("Test-FailedAttempts-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName, AuditFailedAttempts: 2}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		acquired, err := n.TryLock()
+		assert.Nil(t, err)
+		assert.False(t, acquired)
+	}
+	entries, err := m.FailedAttempts()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, n.id, entry.LockerID)
+		assert.True(t, entry.Time.After(started))
+	}
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
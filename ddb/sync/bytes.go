@@ -0,0 +1,36 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// bytesAttribute is the binary attribute holding the value set with SetValueBytes.
+const bytesAttribute = "Bytes"
+
+// GetValueBytes gets the binary value of the Mutex. It is stored apart from the string value, in the Bytes
+// attribute of the lock item, so binary payloads like serialized protobufs are not encoded as strings. It returns nil
+// if no binary value was set.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueBytes() []byte {
+	return m.bytes
+}
+
+// SetValueBytes sets the binary value of the Mutex, next to its string value. It does not check if the Mutex was
+// locked beforehand. It does not write the value into the database. The value is written to the database during
+// Unlock; an empty value removes the attribute.
+func (m *Mutex) SetValueBytes(value []byte) {
+	m.bytes = value
+	m.valueSet = true
+}
+
+// setBytes adds the binary value of the Mutex to the SET or REMOVE actions of an unlock.
+func (m *Mutex) setBytes(set, remove []string, names map[string]*string, values map[string]*dynamodb.AttributeValue) ([]string, []string) {
+	names["#bytes"] = aws.String(bytesAttribute)
+	if len(m.bytes) == 0 {
+		return set, append(remove, "#bytes")
+	}
+	values[":bytes"] = &dynamodb.AttributeValue{B: m.bytes}
+	return append(set, "#bytes=:bytes"), remove
+}
@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+)
+
+// UnlockIfValue writes the value of the Mutex into the database and unlocks it, like UnlockWithError, but only if
+// the stored value is still expected, usually the value read when the Mutex was locked. It gives optimistic
+// concurrency on top of the lock, for example against a holder that took the lock over after it expired and
+// changed the value. A lock item without a value, which was never unlocked, has the value "0", as read by Lock.
+//
+// The stored value is expected whether it was written compressed or not, regardless of CompressThreshold. A
+// compressed value is compared in its compressed form, so it only matches if it was compressed the same way, by
+// this package built with the same Go version.
+//
+// If the stored value is not expected, nothing is written and the error is ErrValueChanged. The Mutex is still
+// locked then, unless the lock was taken over, so it can be unlocked without the value. If the lock was taken over
+// but the value was not changed, the error is ErrFencedOut, like for UnlockWithError.
+func (m *Mutex) UnlockIfValue(expected string) (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = m.writeUnlock(false, &expected)
+	if err == nil {
+		m.metrics().IncUnlock()
+		m.logf("unlocked %s", m.Name)
+		return
+	}
+	if !isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return
+	}
+	if !m.locked {
+		return ErrNotOwner
+	}
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	held := m.holds(item)
+	if !held {
+		m.released()
+	}
+	if value, _, decodeErr := decodeValue(item, m.ValueAttributeName); decodeErr == nil && value == expected && !held {
+		return ErrFencedOut
+	}
+	return ErrValueChanged
+}
+
+// UnlockIfValueInt64 is UnlockIfValue for int64 values.
+func (m *Mutex) UnlockIfValueInt64(expected int64) error {
+	return m.UnlockIfValue(strconv.FormatInt(expected, 10))
+}
+
+// holds reports whether a lock item is held by the Mutex, with the fencing token it acquired it with.
+func (m *Mutex) holds(item map[string]*dynamodb.AttributeValue) bool {
+	id, owner, fence := item[m.LockerIDAttributeName], item[ownerAttribute], item["Fence"]
+	return id != nil && id.N != nil && *id.N == strconv.FormatInt(m.id, 10) &&
+		owner != nil && owner.S != nil && *owner.S == m.owner &&
+		fence != nil && fence.N != nil && *fence.N == strconv.FormatUint(m.fence, 10)
+}
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_UnlockIfValue_Offline(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+		"Value":            {S: aws.String("5")},
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.Nil(t, m.LockWithError())
+	m.SetValueInt64(6)
+	assert.Nil(t, m.UnlockIfValueInt64(5))
+	assert.Contains(t, *writes.input.ConditionExpression, "#value = :expected")
+	assert.NotContains(t, *writes.input.ConditionExpression, "attribute_not_exists(#value)")
+	assert.Equal(t, "5", *writes.input.ExpressionAttributeValues[":expected"].S)
+	assert.Equal(t, "6", *writes.input.ExpressionAttributeValues[":value"].S)
+	// Values written with another CompressThreshold match too
+	assert.Contains(t, *writes.input.ConditionExpression, "#value = :expected AND attribute_not_exists(#encoding)")
+	assert.Contains(t, *writes.input.ConditionExpression, "#value = :expectedgzip AND #encoding = :gzip")
+	compressed, err := compress("5")
+	assert.Nil(t, err)
+	assert.Equal(t, compressed, *writes.input.ExpressionAttributeValues[":expectedgzip"].S)
+	assert.Nil(t, m.LockWithError())
+	assert.Nil(t, m.UnlockIfValue("0"))
+	assert.Contains(t, *writes.input.ConditionExpression, "attribute_not_exists(#value)") // A new lock reads as 0
+}
+
+func Test_UnlockIfValue_Changed(t *testing.T) {
+	changed := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	m := Mutex{DDBSession: changed}
+	assert.Nil(t, m.initialization())
+	m.locked = true
+	assert.Equal(t, ErrValueChanged, m.UnlockIfValue("5"))
+	assert.False(t, m.locked) // Not held by the Mutex anymore
+	assert.Equal(t, ErrNotOwner, m.UnlockIfValue("5"))
+}
+
+func Test_UnlockIfValue_Compressed(t *testing.T) {
+	TableName := fmt.Sprintf("Test-UnlockIfValue-Compressed-%d", time.Now().Unix())
+	value := strings.Repeat("compressible ", 100)
+	plain := Mutex{DDBTableName: TableName}
+	compressing := Mutex{DDBTableName: TableName, CompressThreshold: 10}
+	assert.NotPanics(t, compressing.Lock)
+	compressing.SetValueString(value)
+	assert.NotPanics(t, compressing.Unlock)
+	assert.Equal(t, value, plain.LockAndGetValueString())
+	plain.SetValueString("plain")
+	assert.Nil(t, plain.UnlockIfValue(value)) // Stored compressed
+	assert.Equal(t, "plain", compressing.LockAndGetValueString())
+	assert.Nil(t, compressing.UnlockIfValue("plain")) // Stored as is
+	DeleteTable(plain)
+}
+
+func Test_UnlockIfValue(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-UnlockIfValue-%d", time.Now().Unix())
+	clock := &fakeClock{now: time.Now()}
+	m := Mutex{DDBTableName: TableName, Expiry: expiry, Clock: clock}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry, Clock: clock}
+	assert.NotPanics(t, m.Lock)
+	read := m.GetValueInt64()
+	m.SetValueInt64(read + 1)
+	clock.Advance(expiry) // m stalls, and its lock is stolen
+	assert.NotPanics(t, n.Lock)
+	n.SetValueString("stolen")
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, ErrValueChanged, m.UnlockIfValueInt64(read))
+	assert.Equal(t, "stolen", n.LockAndGetValueString())
+	assert.Nil(t, n.UnlockIfValue("stolen"))
+	DeleteTable(m)
+}
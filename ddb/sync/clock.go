@@ -0,0 +1,25 @@
+package sync
+
+import "time"
+
+// A Clock tells the time to a Mutex. The Mutex uses it for the timestamps it writes to lock items and for the
+// expiry and timeout of locks, so tests can let time pass without waiting. Delays, like the ones between attempts to
+// lock and before ExpiryWarning, are still real.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock used when none is set.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clock returns the Clock of the Mutex.
+func (m *Mutex) clock() Clock {
+	if m.Clock == nil {
+		return systemClock{}
+	}
+	return m.Clock
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when it is advanced, and by step on every reading, so timeouts elapse
+// after a few attempts.
+type fakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Test_Clock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	m := Mutex{Clock: clock, Expiry: time.Minute}
+	assert.Equal(t, time.Unix(1000, 0), m.clock().Now())
+	assert.Equal(t, time.Unix(940, 0).UnixNano(), m.expiredBefore())
+	assert.IsType(t, systemClock{}, (&Mutex{}).clock())
+}
+
+func Test_Clock_Peek(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	table := &replica{item: map[string]*dynamodb.AttributeValue{
+		"LockerID":  {N: aws.String("7")},
+		"LastWrite": FormatLastWrite(clock.Now()),
+	}}
+	m := Mutex{DDBSession: table, Expiry: time.Minute, Clock: clock}
+	held, _, expiresAt, err := m.Peek()
+	assert.Nil(t, err)
+	assert.True(t, held)
+	assert.Equal(t, time.Unix(1060, 0), expiresAt)
+	clock.Advance(time.Minute) // Expires without waiting
+	held, _, _, err = m.Peek()
+	assert.Nil(t, err)
+	assert.False(t, held)
+}
+
+func Test_Clock_Timeout(t *testing.T) {
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	clock := &fakeClock{now: time.Unix(1000, 0), step: time.Second}
+	m := Mutex{DDBSession: held, Clock: clock, MaxBackoff: time.Millisecond}.WithTimeout(time.Minute)
+	started := time.Now()
+	assert.Equal(t, ErrLockTimeout, m.LockWithError())
+	assert.True(t, time.Since(started) < time.Second) // A minute passed on the clock
+}
@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"math"
+	"strings"
+	"time"
+)
+
+// setIf applies a SET expression to the lock item of the Mutex if condition holds. The placeholders of the
+// expressions are taken from names and numeric values; only the ones used by the expressions are sent, as DynamoDB
+// rejects unused ones. It is the building block of the lock types that keep counters in a lock item, like RWMutex
+// and Semaphore.
+func (m *Mutex) setIf(set string, condition string, names map[string]string, values map[string]string) (*dynamodb.UpdateItemOutput, error) {
+	expressions := set + " " + condition
+	expressionAttributeNames := map[string]*string{}
+	for placeholder, name := range names {
+		if strings.Contains(expressions, placeholder) {
+			expressionAttributeNames[placeholder] = aws.String(name)
+		}
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	for placeholder, value := range values {
+		if strings.Contains(expressions, placeholder) {
+			expressionAttributeValues[placeholder] = &dynamodb.AttributeValue{N: aws.String(value)}
+		}
+	}
+	return m.updateItem("setIf", &dynamodb.UpdateItemInput{
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		Key:                       m.key(m.Name),
+		UpdateExpression:          aws.String("SET " + set),
+		TableName:                 &m.DDBTableName,
+	})
+}
+
+// retryConditional calls try until it succeeds, waiting between attempts like Lock. It returns ErrLockTimeout if the
+// timeout of the Mutex elapses first, and any error other than a failed condition immediately.
+func (m *Mutex) retryConditional(try func() error) (err error) {
+	started := m.clock().Now()
+	for attempt := 0; ; attempt++ {
+		err = try()
+		if !isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			return
+		}
+		m.metrics().IncContention()
+		remaining := time.Duration(math.MaxInt64)
+		if m.timeout > 0 {
+			remaining = m.timeout - m.clock().Now().Sub(started)
+			if remaining <= 0 {
+				m.metrics().IncTimeout()
+				return ErrLockTimeout
+			}
+		}
+		if err = sleep(context.Background(), capBackoff(m.retryDelay(attempt), m.MaxBackoff, remaining)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// GetValueDecimal gets the value from the Mutex and returns it as an exact rational number. It accepts decimals like
+// "12.34" and fractions like "1/3". An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueDecimal() (*big.Rat, error) {
+	if m.value == "" {
+		return new(big.Rat), nil
+	}
+	value, ok := new(big.Rat).SetString(m.value)
+	if !ok {
+		return nil, fmt.Errorf("value is not a decimal: %q", m.value)
+	}
+	return value, nil
+}
+
+// SetValueDecimal sets an exact rational number as the value in the Mutex. It does not check if the Mutex was locked
+// beforehand. It does not write the value into the database. The value is written to the database during Unlock.
+//
+// The value is stored as a decimal without trailing zeros, like "12.34", or as a reduced fraction, like "1/3", if it
+// has no finite decimal representation. Both forms are read back exactly by GetValueDecimal.
+func (m *Mutex) SetValueDecimal(value *big.Rat) {
+	m.value = formatDecimal(value)
+	m.valueSet = true
+}
+
+// formatDecimal returns the canonical string form of a rational number used by SetValueDecimal.
+func formatDecimal(value *big.Rat) string {
+	if value.IsInt() {
+		return value.Num().String()
+	}
+	// A fraction has a finite decimal representation if its denominator only has the prime factors 2 and 5.
+	// The number of decimals is the larger of their exponents.
+	denominator := new(big.Int).Set(value.Denom())
+	twos := factorOut(denominator, 2)
+	fives := factorOut(denominator, 5)
+	if denominator.Cmp(big.NewInt(1)) != 0 {
+		return value.RatString()
+	}
+	decimals := twos
+	if fives > decimals {
+		decimals = fives
+	}
+	return value.FloatString(decimals)
+}
+
+// factorOut divides n by factor as often as possible and returns how often it did.
+func factorOut(n *big.Int, factor int64) (count int) {
+	divisor := big.NewInt(factor)
+	quotient, remainder := new(big.Int), new(big.Int)
+	for {
+		quotient.QuoRem(n, divisor, remainder)
+		if remainder.Sign() != 0 {
+			return
+		}
+		n.Set(quotient)
+		count++
+	}
+}
@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func Test_FormatDecimal(t *testing.T) {
+	for value, expected := range map[string]string{
+		"5":        "5",
+		"-12.340":  "-12.34",
+		"1/8":      "0.125",
+		"3/40":     "0.075",
+		"1/3":      "1/3",
+		"2/6":      "1/3",
+		"100.0001": "100.0001",
+	} {
+		r, ok := new(big.Rat).SetString(value)
+		assert.True(t, ok)
+		assert.Equal(t, expected, formatDecimal(r), value)
+	}
+}
+
+func Test_ValueDecimal(t *testing.T) {
+	m := Mutex{}
+	value, err := m.GetValueDecimal()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, value.Sign())
+	price, _ := new(big.Rat).SetString("19.99")
+	m.SetValueDecimal(price)
+	assert.Equal(t, "19.99", m.GetValueString())
+	value, err = m.GetValueDecimal()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, value.Cmp(price))
+	m.SetValueDecimal(new(big.Rat).Quo(price, big.NewRat(3, 1)))
+	value, err = m.GetValueDecimal()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, value.Mul(value, big.NewRat(3, 1)).Cmp(price))
+	m.SetValueString("a lot")
+	_, err = m.GetValueDecimal()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+)
+
+const (
+	// encodingGzip marks a value that is gzip compressed and then base64 encoded.
+	encodingGzip = "gzip"
+
+	// maxValueSize is the largest stored value that fits in a 400 KB DynamoDB item,
+	// leaving room for the key and the lock attributes.
+	maxValueSize = 400*1024 - 1024
+)
+
+// encodeValue prepares a value for writing to the database. It returns the stored form of the value and the name of
+// its encoding. The encoding is empty for values that are stored as-is. Stored forms larger than maxValueSize fail
+// with ErrValueTooLarge, whether they are compressed or not.
+func (m *Mutex) encodeValue(value string) (stored string, encoding string, err error) {
+	stored = value
+	if m.CompressThreshold > 0 && len(value) > m.CompressThreshold {
+		if stored, err = compress(value); err != nil {
+			return
+		}
+		encoding = encodingGzip
+	}
+	if len(stored) > maxValueSize {
+		return "", "", ErrValueTooLarge
+	}
+	return stored, encoding, nil
+}
+
+// compress returns the gzip encoding of a value: gzip compressed and then base64 encoded.
+func compress(value string) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeValue extracts the value from the given attribute of a lock item. It returns false if the item has no value.
+func decodeValue(item map[string]*dynamodb.AttributeValue, valueAttribute string) (value string, ok bool, err error) {
+	attribute, ok := item[valueAttribute]
+	if !ok {
+		return
+	}
+	if attribute.S == nil {
+		return "", false, errors.New("value attribute is not a string")
+	}
+	value = *attribute.S
+
+	encoding := ""
+	if attribute, found := item["Encoding"]; found {
+		encoding = *attribute.S
+	}
+	switch encoding {
+	case "":
+	case encodingGzip:
+		compressed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", false, err
+		}
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return "", false, err
+		}
+		decompressed, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", false, err
+		}
+		value = string(decompressed)
+	default:
+		return "", false, fmt.Errorf("unknown value encoding: %s", encoding)
+	}
+	return
+}
+
+// checksum returns the checksum of a value in its stored form, as written to the Checksum attribute.
+func checksum(stored string) string {
+	sum := sha256.Sum256([]byte(stored))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChecksum checks the stored value of a lock item, in the given attribute, against its checksum. Items without
+// a checksum, written by a Mutex without VerifyIntegrity, pass the check.
+func verifyChecksum(item map[string]*dynamodb.AttributeValue, valueAttribute string) error {
+	sum, ok := item["Checksum"]
+	if !ok {
+		return nil
+	}
+	stored := ""
+	if value, ok := item[valueAttribute]; ok && value.S != nil {
+		stored = *value.S
+	}
+	if sum.S == nil || *sum.S != checksum(stored) {
+		return ErrIntegrityMismatch
+	}
+	return nil
+}
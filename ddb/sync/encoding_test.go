@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func testItem(value string, encoding string) map[string]*dynamodb.AttributeValue {
+	result := map[string]*dynamodb.AttributeValue{
+		"Value": {S: aws.String(value)},
+	}
+	if encoding != "" {
+		result["Encoding"] = &dynamodb.AttributeValue{S: aws.String(encoding)}
+	}
+	return result
+}
+
+func Test_EncodeValue_BelowThreshold(t *testing.T) {
+	m := Mutex{CompressThreshold: 10}
+	stored, encoding, err := m.encodeValue("short")
+	assert.Nil(t, err)
+	assert.Equal(t, "short", stored)
+	assert.Equal(t, "", encoding)
+}
+
+func Test_EncodeValue_Compressed(t *testing.T) {
+	m := Mutex{CompressThreshold: 10}
+	value := strings.Repeat("compressible ", 1000)
+	stored, encoding, err := m.encodeValue(value)
+	assert.Nil(t, err)
+	assert.Equal(t, encodingGzip, encoding)
+	assert.True(t, len(stored) < len(value))
+	decoded, ok, err := decodeValue(testItem(stored, encoding), "Value")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, decoded)
+}
+
+func Test_EncodeValue_TooLarge(t *testing.T) {
+	m := Mutex{CompressThreshold: 10}
+	random := make([]byte, maxValueSize)
+	_, err := rand.Read(random)
+	assert.Nil(t, err)
+	_, _, err = m.encodeValue(base64.StdEncoding.EncodeToString(random))
+	assert.Equal(t, ErrValueTooLarge, err)
+}
+
+func Test_EncodeValue_TooLargeUncompressed(t *testing.T) {
+	m := Mutex{}
+	_, _, err := m.encodeValue(strings.Repeat("x", maxValueSize+1))
+	assert.Equal(t, ErrValueTooLarge, err)
+	stored, _, err := m.encodeValue(strings.Repeat("x", maxValueSize))
+	assert.Nil(t, err)
+	assert.Len(t, stored, maxValueSize)
+}
+
+func Test_DecodeValue(t *testing.T) {
+	_, ok, err := decodeValue(map[string]*dynamodb.AttributeValue{}, "Value")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	value, ok, err := decodeValue(testItem("plain", ""), "Value")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "plain", value)
+	_, _, err = decodeValue(testItem("plain", "rot13"), "Value")
+	assert.NotNil(t, err)
+	_, _, err = decodeValue(testItem("not base64!", encodingGzip), "Value")
+	assert.NotNil(t, err)
+	_, _, err = decodeValue(map[string]*dynamodb.AttributeValue{"Value": {N: aws.String("5")}}, "Value")
+	assert.NotNil(t, err)
+}
+
+func Test_VerifyChecksum(t *testing.T) {
+	item := testItem("plain", "")
+	assert.Nil(t, verifyChecksum(item, "Value")) // No checksum
+	item["Checksum"] = &dynamodb.AttributeValue{S: aws.String(checksum("plain"))}
+	assert.Nil(t, verifyChecksum(item, "Value"))
+	item["Value"] = &dynamodb.AttributeValue{S: aws.String("tampered")}
+	assert.Equal(t, ErrIntegrityMismatch, verifyChecksum(item, "Value"))
+	delete(item, "Value")
+	assert.Equal(t, ErrIntegrityMismatch, verifyChecksum(item, "Value"))
+}
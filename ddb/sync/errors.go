@@ -0,0 +1,73 @@
+package sync
+
+import "errors"
+
+// The methods of the Mutex that return errors return these sentinels, or the errors of DynamoDB. Errors of DynamoDB
+// are returned as is or wrapped with context, so errors.As finds the awserr.Error, and errors.Is the sentinels. For
+// example, ErrLockTimeout means that the lock is held by someone else, and is worth retrying later, while an
+// awserr.Error means that DynamoDB could not be reached or refused the request.
+var (
+	// ErrAlreadyHeld is returned when locking a Mutex that is already locked by the same Mutex instance.
+	// A Mutex is not reentrant: locking it twice is usually a bug in the caller.
+	ErrAlreadyHeld = errors.New("lock is already held by this Mutex")
+
+	// ErrCredentialsExpired is returned when a DynamoDB call fails because the AWS credentials expired and could
+	// not be refreshed. The returned error also wraps the error of DynamoDB.
+	ErrCredentialsExpired = errors.New("AWS credentials expired")
+
+	// ErrFencedOut is returned by Unlock when the lock expired and was taken over by another holder. The value of
+	// the Mutex is not written, so it does not overwrite changes made by the new holder.
+	ErrFencedOut = errors.New("lock was taken over by another holder")
+
+	// ErrIntegrityMismatch is returned when the value of a lock item does not match the checksum written with it,
+	// because the item was changed outside of this package. See VerifyIntegrity.
+	ErrIntegrityMismatch = errors.New("value does not match its checksum")
+
+	// ErrLockTimeout is returned when the lock could not be acquired before the timeout of the Mutex elapsed, because
+	// it was held by someone else all along. With DiagnoseConflicts, it is wrapped with the holder of the lock.
+	ErrLockTimeout = errors.New("could not lock mutex")
+
+	// ErrLockRetired is returned when locking a lock that was retired with Retire.
+	ErrLockRetired = errors.New("lock is retired")
+
+	// ErrMaxAttempts is returned when the lock could not be acquired in MaxAttempts attempts, because it was held by
+	// someone else all along.
+	ErrMaxAttempts = errors.New("could not lock mutex in the maximum number of attempts")
+
+	// ErrNotOwner is returned when an operation requires the lock to be held by the Mutex, but it is not.
+	ErrNotOwner = errors.New("lock is not held by this Mutex")
+
+	// ErrShuttingDown is returned when locking a Mutex after ReleaseOnSignal started releasing the locks of the
+	// process.
+	ErrShuttingDown = errors.New("locks of this process are being released")
+
+	// ErrTableNotReady is returned when the table of the Mutex did not become active within TableReadyTimeout.
+	ErrTableNotReady = errors.New("table is not active")
+
+	// ErrTooManyLocksHeld is returned when acquiring a lock would exceed MaxHeldLocks.
+	ErrTooManyLocksHeld = errors.New("too many locks held by this process")
+
+	// ErrValueChanged is returned by UnlockIfValue when the stored value is not the expected one. The value of the
+	// Mutex is not written.
+	ErrValueChanged = errors.New("value was changed")
+
+	// ErrValueTooLarge is returned when a value does not fit in a DynamoDB item, even after compression.
+	ErrValueTooLarge = errors.New("value too large")
+)
+
+// credentialsExpiredError is ErrCredentialsExpired, wrapping the error returned by DynamoDB.
+type credentialsExpiredError struct {
+	err error
+}
+
+func (e *credentialsExpiredError) Error() string {
+	return ErrCredentialsExpired.Error() + ": " + e.err.Error()
+}
+
+func (e *credentialsExpiredError) Is(target error) bool {
+	return target == ErrCredentialsExpired
+}
+
+func (e *credentialsExpiredError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// Fair locks are ticket locks kept in the lock item. Lock takes a ticket by incrementing NextTicket, and may only
+// take the lock once ServingTicket, the ticket of the last admitted holder, has reached the ticket before its own.
+// Admission then sets ServingTicket to its ticket, which lets the next waiter in. A waiter that gave up or died
+// leaves a gap in the tickets, so a lock that stays free for fairStall is open to any waiter again.
+
+// fairStallRetries is the number of retry delays a free lock waits for the next ticket in line.
+const fairStallRetries = 4
+
+// takeTicket takes the next ticket of the lock queue. It creates the lock item, if it does not exist yet.
+func (m *Mutex) takeTicket() (err error) {
+	result, err := m.updateItem("takeTicket", &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#nextticket": aws.String("NextTicket"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+		Key:              m.key(m.Name),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedNew),
+		UpdateExpression: aws.String("ADD #nextticket :one"),
+		TableName:        &m.DDBTableName,
+	})
+	if err != nil {
+		return
+	}
+	m.ticket, err = strconv.ParseInt(*result.Attributes["NextTicket"].N, 10, 64)
+	return
+}
+
+// fairCondition returns the condition that admits the Mutex in turn, and the SET action that lets the next ticket
+// in. A Mutex without a ticket, like in TryLock, is only admitted if nobody is queued.
+func (m *Mutex) fairCondition(lastWrite int64, names map[string]*string, values map[string]*dynamodb.AttributeValue) (condition string, set []string) {
+	names["#serving"] = aws.String("ServingTicket")
+	names["#nextticket"] = aws.String("NextTicket")
+	values[":stalled"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(lastWrite-m.fairStall().Nanoseconds(), 10)),
+	}
+	stalled := "#lastwrite < :stalled"
+	if m.ticket == 0 {
+		return "attribute_not_exists(#nextticket) OR #serving >= #nextticket OR " + stalled, nil
+	}
+	values[":ticket"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(m.ticket, 10)),
+	}
+	values[":prev"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(m.ticket-1, 10)),
+	}
+	return "attribute_not_exists(#serving) OR #serving >= :prev OR " + stalled, []string{"#serving=:ticket"}
+}
+
+// fairStall returns how long a free lock waits for the next ticket in line before later tickets may take it: a few
+// times the longest delay between two attempts to lock, so a live waiter has had its chances.
+func (m *Mutex) fairStall() time.Duration {
+	longest := m.retryBound(64)
+	if m.MaxBackoff > 0 && longest > m.MaxBackoff {
+		longest = m.MaxBackoff
+	}
+	return fairStallRetries * longest
+}
@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Fair_Offline(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"NextTicket":       {N: aws.String("3")},
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes, Fair: true}
+	assert.Nil(t, m.LockWithError())
+	assert.Contains(t, *writes.input.ConditionExpression, "#serving >= :prev")
+	assert.Contains(t, *writes.input.UpdateExpression, "#serving=:ticket")
+	assert.Equal(t, "3", *writes.input.ExpressionAttributeValues[":ticket"].N)
+	assert.Equal(t, "2", *writes.input.ExpressionAttributeValues[":prev"].N)
+	assert.Equal(t, int64(0), m.ticket)
+	assert.Nil(t, m.UnlockWithError())
+
+	acquired, err := m.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Contains(t, *writes.input.ConditionExpression, "#serving >= #nextticket") // Only if nobody is queued
+	assert.NotContains(t, *writes.input.UpdateExpression, "#serving")
+	assert.NotContains(t, writes.input.ExpressionAttributeValues, ":ticket")
+}
+
+func Test_FairStall(t *testing.T) {
+	assert.Equal(t, 4*maxRetryDelay, (&Mutex{}).fairStall())
+	assert.Equal(t, 4*maxRetryBackoff, (&Mutex{RetryBackoff: time.Millisecond}).fairStall())
+	assert.Equal(t, 40*time.Millisecond, (&Mutex{MaxBackoff: 10 * time.Millisecond}).fairStall())
+}
+
+func Test_Fair_Order(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Fair-%d", time.Now().Unix())
+	thisMany := 5
+	holder := Mutex{DDBTableName: TableName, Fair: true}
+	assert.NotPanics(t, holder.Lock)
+	order := []int{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func(i int) {
+			defer wg.Done()
+			m := Mutex{DDBTableName: TableName, Fair: true}.WithTimeout(30 * time.Second)
+			assert.NotPanics(t, m.Lock)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			assert.NotPanics(t, m.Unlock)
+		}(i)
+		// Waiters arrive one after the other, each waiting longer than the next
+		time.Sleep(300 * time.Millisecond)
+	}
+	assert.NotPanics(t, holder.Unlock)
+	wg.Wait()
+	// Without fairness, the order is random. Allow two waiters to swap places, for example after a slow retry.
+	misplaced := 0
+	for position, i := range order {
+		if position != i {
+			misplaced++
+		}
+	}
+	assert.True(t, misplaced <= 2, "order %v", order)
+	DeleteTable(holder)
+}
@@ -0,0 +1,296 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A MutexGroup vends named locks that share the configuration of a base Mutex: the AWS and DynamoDB sessions, the
+// table and options like Expiry. It keeps track of the locks it holds, so they can be refreshed or released
+// together, for example at shutdown.
+//
+// Get, Lock and Unlock are safe for concurrent use: Lock waits while another goroutine holds the named lock, like
+// for a holder in another process. Each named lock has a LockerID of its own, so it excludes other Mutexes of the
+// process with the same name, including the base Mutex. The Mutexes returned by Get are shared: set their values
+// only while holding their locks. The methods of the group that act on all held locks should only be used by one
+// goroutine at a time.
+type MutexGroup struct {
+	base    *Mutex
+	mu      sync.Mutex
+	mutexes map[string]*Mutex
+}
+
+// NewMutexGroup returns a MutexGroup whose locks are configured like base. The Name of base is ignored.
+// The table name is resolved once for the group, so "{name}" in TableNameTemplate is not supported.
+// The base Mutex must not be used directly after it is passed in.
+func NewMutexGroup(base *Mutex) *MutexGroup {
+	return &MutexGroup{
+		base:    base,
+		mutexes: make(map[string]*Mutex),
+	}
+}
+
+// Get returns the Mutex of the lock with the given name, creating it on the first call.
+func (g *MutexGroup) Get(name string) (m *Mutex, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if m, ok := g.mutexes[name]; ok {
+		return m, nil
+	}
+	// Sessions, table checks and the OwnerID are shared by the locks of the group
+	err = g.base.initialization()
+	if err != nil {
+		return
+	}
+	m, err = g.base.forName(name)
+	if err != nil {
+		return
+	}
+	g.mutexes[name] = m
+	return
+}
+
+// forName returns a Mutex for the lock with the given name, configured like m. The configuration, sessions and
+// OwnerID are copied, the state of the lock is not, so the Mutex may be locked itself. It gets a LockerID of its
+// own, so its lock and the lock of m exclude each other. Sessions created by m are shared, but still owned by m.
+func (m *Mutex) forName(name string) (copied *Mutex, err error) {
+	id, err := newLockerID()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied = &Mutex{
+		Name:                   name,
+		DefaultValue:           m.DefaultValue,
+		RequestID:              m.RequestID,
+		OwnerID:                m.OwnerID,
+		Expiry:                 m.Expiry,
+		ExpirySkewTolerance:    m.ExpirySkewTolerance,
+		StaleGracePeriod:       m.StaleGracePeriod,
+		ExpiryWarning:          m.ExpiryWarning,
+		OnExpiryWarning:        m.OnExpiryWarning,
+		AutoRenew:              m.AutoRenew,
+		OnRefreshError:         m.OnRefreshError,
+		AuditFailedAttempts:    m.AuditFailedAttempts,
+		Cooldown:               m.Cooldown,
+		UnlockMissingTable:     m.UnlockMissingTable,
+		DiagnoseConflicts:      m.DiagnoseConflicts,
+		TrackWaiters:           m.TrackWaiters,
+		InitialJitter:          m.InitialJitter,
+		MaxAttempts:            m.MaxAttempts,
+		MaxBackoff:             m.MaxBackoff,
+		RetryBackoff:           m.RetryBackoff,
+		DisableJitter:          m.DisableJitter,
+		Fair:                   m.Fair,
+		AWSRegion:              m.AWSRegion,
+		AWSPartition:           m.AWSPartition,
+		Endpoint:               m.Endpoint,
+		AWSSession:             m.AWSSession,
+		IgnoreEnvVars:          m.IgnoreEnvVars,
+		AWSProfile:             m.AWSProfile,
+		RoleARN:                m.RoleARN,
+		ExternalID:             m.ExternalID,
+		DDBSession:             m.DDBSession,
+		DDBReadSession:         m.DDBReadSession,
+		DDBTableName:           m.DDBTableName,
+		KeyAttributeName:       m.KeyAttributeName,
+		ValueAttributeName:     m.ValueAttributeName,
+		LastWriteAttributeName: m.LastWriteAttributeName,
+		LockerIDAttributeName:  m.LockerIDAttributeName,
+		TableNameTemplate:      m.TableNameTemplate,
+		Tenant:                 m.Tenant,
+		SanitizeTableName:      m.SanitizeTableName,
+		BillingMode:            m.BillingMode,
+		ReadCapacityUnits:      m.ReadCapacityUnits,
+		WriteCapacityUnits:     m.WriteCapacityUnits,
+		TablePollInterval:      m.TablePollInterval,
+		TableReadyTimeout:      m.TableReadyTimeout,
+		SSEEnabled:             m.SSEEnabled,
+		SSEKMSKeyID:            m.SSEKMSKeyID,
+		Tags:                   m.Tags,
+		EnableTTL:              m.EnableTTL,
+		TTLRetention:           m.TTLRetention,
+		Logger:                 m.Logger,
+		Metrics:                m.Metrics,
+		Clock:                  m.Clock,
+		LogConsumedCapacity:    m.LogConsumedCapacity,
+		CompressThreshold:      m.CompressThreshold,
+		VerifyIntegrity:        m.VerifyIntegrity,
+
+		initialized: m.initialized,
+		timeout:     m.timeout,
+		timeoutSet:  m.timeoutSet,
+		id:          id,
+		owner:       m.owner,
+	}
+	copied.value = "0"
+	return
+}
+
+// Lock locks the lock with the given name, waiting up to the timeout of the base Mutex, also while another
+// goroutine holds it through the group.
+func (g *MutexGroup) Lock(name string) error {
+	m, err := g.Get(name)
+	if err != nil {
+		return err
+	}
+	return m.lockShared()
+}
+
+// lockShared locks a Mutex shared by goroutines, like the locks of a MutexGroup. While another goroutine holds the
+// Mutex or is locking it, it waits like Lock does for a holder in another process, instead of failing with
+// ErrAlreadyHeld. The timeout of the Mutex covers the whole wait.
+func (m *Mutex) lockShared() (err error) {
+	started := m.clock().Now()
+	for attempt := 0; ; attempt++ {
+		remaining := m.timeout
+		if remaining > 0 {
+			remaining -= m.clock().Now().Sub(started)
+			if remaining <= 0 {
+				m.metrics().IncTimeout()
+				return ErrLockTimeout
+			}
+		}
+		err = m.lockWithin(context.Background(), remaining)
+		if err != ErrAlreadyHeld {
+			return
+		}
+		delay := m.retryDelay(attempt)
+		if remaining > 0 && delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Unlock unlocks the lock with the given name, writing its value into the database.
+func (g *MutexGroup) Unlock(name string) error {
+	m, err := g.Get(name)
+	if err != nil {
+		return err
+	}
+	return m.unlock()
+}
+
+// Held returns the names of the locks of the group that are currently held, in alphabetical order.
+func (g *MutexGroup) Held() (names []string) {
+	for _, m := range g.locked() {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// RefreshAll renews the lease of every held lock of the group. It refreshes all of them and returns the first
+// error encountered.
+func (g *MutexGroup) RefreshAll() (err error) {
+	for _, m := range g.locked() {
+		if refreshErr := m.Refresh(); refreshErr != nil && err == nil {
+			err = refreshErr
+		}
+	}
+	return
+}
+
+// UnlockAll unlocks every held lock of the group. It unlocks all of them and returns the first error encountered.
+func (g *MutexGroup) UnlockAll() (err error) {
+	for _, m := range g.locked() {
+		if unlockErr := m.unlock(); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}
+	return
+}
+
+// Close unlocks every held lock of the group and closes the shared sessions, like Mutex.Close.
+// Use it for graceful shutdown.
+func (g *MutexGroup) Close() (err error) {
+	err = g.UnlockAll()
+	if closeErr := g.base.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// LockKey locks the lock with the given name, using the configuration and sessions of the Mutex, so one Mutex can
+// lock many names without a Mutex literal for each. The state of the locks is tracked by name, like in a MutexGroup
+// based on the Mutex; Key gives access to their values. LockKey and UnlockKey are safe for concurrent use: locking
+// a name that is already locked through the Mutex waits until it is unlocked or the timeout of the Mutex elapses,
+// like for a lock held by another process. The lock of each name excludes the Mutex itself, even for its own Name.
+func (m *Mutex) LockKey(name string) error {
+	return m.group().Lock(name)
+}
+
+// UnlockKey writes the value of the lock with the given name into the database and unlocks it, see LockKey.
+func (m *Mutex) UnlockKey(name string) error {
+	return m.group().Unlock(name)
+}
+
+// Key returns the Mutex of the lock with the given name used by LockKey and UnlockKey, to get or set its value.
+func (m *Mutex) Key(name string) (*Mutex, error) {
+	return m.group().Get(name)
+}
+
+// LockAll locks the locks with the given names like LockKey, in alphabetical order, so processes and goroutines
+// locking overlapping sets of names cannot deadlock each other. Names held by another goroutine are waited for. If a lock cannot be taken, the locks already taken are
+// unlocked and the error is returned. Otherwise unlock unlocks all of them in reverse order, handling errors like
+// Unlock. Duplicate names are locked once.
+func (m *Mutex) LockAll(names ...string) (unlock func(), err error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	held := make([]*Mutex, 0, len(sorted))
+	release := func() (err error) {
+		for i := len(held) - 1; i >= 0; i-- {
+			if unlockErr := held[i].unlock(); unlockErr != nil && err == nil {
+				err = unlockErr
+			}
+		}
+		return
+	}
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		key, keyErr := m.Key(name)
+		if keyErr == nil {
+			keyErr = key.lockShared()
+		}
+		if keyErr != nil {
+			if rollbackErr := release(); rollbackErr != nil {
+				m.logf("could not unlock %v after failing to lock %s: %v", sorted[:i], name, rollbackErr)
+			}
+			return nil, keyErr
+		}
+		held = append(held, key)
+	}
+	return func() {
+		if err := release(); err != nil {
+			m.fail(err)
+		}
+	}, nil
+}
+
+// group returns the MutexGroup of LockKey, creating it on the first call.
+func (m *Mutex) group() *MutexGroup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys == nil {
+		m.keys = NewMutexGroup(m)
+	}
+	return m.keys
+}
+
+// locked returns the locks of the group that are currently held.
+func (g *MutexGroup) locked() (mutexes []*Mutex) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.mutexes {
+		if m.isLocked() {
+			mutexes = append(mutexes, m)
+		}
+	}
+	return
+}
@@ -0,0 +1,330 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MutexGroup(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-MutexGroup-%d", time.Now().Unix())
+	group := NewMutexGroup(&Mutex{DDBTableName: TableName, Expiry: expiry})
+	other := Mutex{DDBTableName: TableName, Name: "b", Expiry: expiry}.WithTimeout(time.Second)
+	assert.Nil(t, group.Lock("a"))
+	assert.Nil(t, group.Lock("b"))
+	assert.Equal(t, []string{"a", "b"}, group.Held())
+	a, err := group.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", a.Name)
+	a.SetValueString("shared")
+	time.Sleep(expiry / 2)
+	assert.Nil(t, group.RefreshAll())
+	time.Sleep(expiry / 2)
+	assert.Panics(t, other.Lock) // Refreshed, not expired
+	assert.Nil(t, group.Unlock("a"))
+	assert.Equal(t, []string{"b"}, group.Held())
+	assert.Nil(t, group.Close())
+	assert.Nil(t, group.Held())
+	assert.NotPanics(t, other.Lock)
+	assert.NotPanics(t, other.Unlock)
+	DeleteTable(other)
+}
+
+func Test_Refresh(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-Refresh-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Expiry: expiry}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry}
+	assert.Equal(t, ErrNotOwner, m.Refresh())
+	assert.NotPanics(t, m.Lock)
+	firstDeadline, _ := m.ExpiresAt()
+	assert.Nil(t, m.Refresh())
+	deadline, _ := m.ExpiresAt()
+	assert.True(t, deadline.After(firstDeadline))
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock)
+	assert.Equal(t, ErrFencedOut, m.Refresh())
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_LockKey_Offline(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+		"Value":            {S: aws.String("7")},
+	}}
+	m := Mutex{DDBSession: writes}.WithTimeout(50 * time.Millisecond)
+	assert.Nil(t, m.LockWithError())
+	assert.Nil(t, m.LockKey("a"))
+	assert.Equal(t, "a", *writes.input.Key["Name"].S)
+	assert.Equal(t, ErrLockTimeout, m.LockKey("a")) // Waits for the holder
+	a, err := m.Key("a")
+	assert.Nil(t, err)
+	assert.True(t, a.locked)
+	assert.Equal(t, "7", a.GetValueString())
+	assert.NotEqual(t, m.id, a.id) // LockerID of its own
+	assert.Nil(t, a.keys)
+	a.SetValueString("8")
+	assert.Nil(t, m.UnlockKey("a"))
+	assert.Equal(t, "8", *writes.input.ExpressionAttributeValues[":value"].S)
+	assert.True(t, m.locked) // Keys are locked apart from the Mutex
+	assert.Nil(t, m.LockKey("b"))
+	assert.Nil(t, m.Close())
+	assert.Equal(t, []string(nil), m.keys.Held())
+}
+
+func Test_ForName(t *testing.T) {
+	m := Mutex{DDBSession: activeTable{}, DDBReadSession: &failingWrites{}, Logger: &testLogger{}, Metrics: &testMetrics{}, Clock: &fakeClock{}}
+	original := reflect.ValueOf(&m).Elem()
+	// Every exported field is set, so one that forName does not copy is noticed
+	for i := 0; i < original.NumField(); i++ {
+		field := original.Field(i)
+		if !field.CanSet() || !field.IsZero() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString("set")
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			field.SetInt(1)
+		case reflect.Map:
+			field.Set(reflect.MakeMap(field.Type()))
+		case reflect.Ptr:
+			field.Set(reflect.New(field.Type().Elem()))
+		case reflect.Func:
+			field.Set(reflect.MakeFunc(field.Type(), func([]reflect.Value) []reflect.Value { return nil }))
+		default:
+			t.Fatalf("cannot set %s", original.Type().Field(i).Name)
+		}
+	}
+	m.id, m.owner, m.locked, m.fence = 42, "owner", true, 7
+	key, err := m.forName("key")
+	assert.Nil(t, err)
+	copied := reflect.ValueOf(key).Elem()
+	for i := 0; i < original.NumField(); i++ {
+		name := original.Type().Field(i).Name
+		if !original.Field(i).CanSet() || name == "Name" {
+			continue
+		}
+		if original.Field(i).Kind() == reflect.Func {
+			assert.Equal(t, original.Field(i).Pointer(), copied.Field(i).Pointer(), name)
+			continue
+		}
+		assert.Equal(t, original.Field(i).Interface(), copied.Field(i).Interface(), name)
+	}
+	assert.Equal(t, "key", key.Name)
+	assert.NotEqual(t, int64(42), key.id) // LockerID of its own
+	assert.NotEqual(t, int64(0), key.id)
+	assert.Equal(t, "owner", key.owner)
+	assert.False(t, key.locked)
+	assert.False(t, key.ownSession)
+	assert.False(t, key.valueSet) // ForceUnlock leaves its value alone
+	assert.Equal(t, uint64(0), key.fence)
+}
+
+func Test_LockKey_Exclusion(t *testing.T) {
+	table := &lockTable{}
+	m := Mutex{DDBSession: table, RetryBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}.WithTimeout(10 * time.Second)
+	var holders, peak int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if !assert.Nil(t, m.LockKey("a")) {
+					return
+				}
+				if current := atomic.AddInt32(&holders, 1); current > atomic.LoadInt32(&peak) {
+					atomic.StoreInt32(&peak, current)
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				assert.Nil(t, m.UnlockKey("a"))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&peak))
+	assert.Empty(t, table.holders)
+
+	// The lock of a name excludes the Mutex with the same Name
+	n := Mutex{DDBSession: table}.WithTimeout(50 * time.Millisecond)
+	assert.Nil(t, n.LockWithError())
+	assert.Equal(t, ErrLockTimeout, n.LockKey(n.Name))
+	assert.Nil(t, n.UnlockWithError())
+}
+
+func Test_LockKey(t *testing.T) {
+	TableName := fmt.Sprintf("Test-LockKey-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	other := Mutex{DDBTableName: TableName, Name: "b"}.WithTimeout(time.Second)
+	assert.Nil(t, m.LockKey("a"))
+	assert.Nil(t, m.LockKey("b"))
+	assert.Panics(t, other.Lock)
+	b, err := m.Key("b")
+	assert.Nil(t, err)
+	b.SetValueString("shared")
+	assert.Nil(t, m.UnlockKey("b"))
+	assert.Equal(t, "shared", other.LockAndGetValueString())
+	assert.NotPanics(t, other.Unlock)
+	assert.Nil(t, m.Close())
+	DeleteTable(m)
+}
+
+// lockTable is a DynamoDB client that keeps track of the holders of locks, like the conditions of tryLock and
+// writeUnlock would. Locking the name in fail fails.
+type lockTable struct {
+	activeTable
+	mu      sync.Mutex
+	holders map[string]string
+	names   []string
+	fail    string
+}
+
+func (l *lockTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name := *input.Key["Name"].S
+	switch {
+	case strings.Contains(*input.UpdateExpression, "#id=:id"):
+		if name == l.fail {
+			return nil, errors.New("failed")
+		}
+		id := *input.ExpressionAttributeValues[":id"].N
+		if holder, ok := l.holders[name]; ok && holder != id {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "held", nil)
+		}
+		if l.holders == nil {
+			l.holders = make(map[string]string)
+		}
+		l.holders[name] = id
+		l.names = append(l.names, "lock "+name)
+	case strings.Contains(*input.UpdateExpression, "#id=:zero"):
+		delete(l.holders, name)
+		l.names = append(l.names, "unlock "+name)
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}, nil
+}
+
+func (l *lockTable) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func Test_LockAll_Offline(t *testing.T) {
+	table := &lockTable{}
+	m := Mutex{DDBSession: table}
+	unlock, err := m.LockAll("c", "a", "b", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lock a", "lock b", "lock c"}, table.names)
+	unlock()
+	assert.Equal(t, []string{"lock a", "lock b", "lock c", "unlock c", "unlock b", "unlock a"}, table.names)
+	assert.Empty(t, table.holders)
+
+	// Roll back on failure
+	table = &lockTable{fail: "b"}
+	m = Mutex{DDBSession: table}
+	unlock, err = m.LockAll("c", "b", "a")
+	assert.EqualError(t, err, "failed")
+	assert.Nil(t, unlock)
+	assert.Equal(t, []string{"lock a", "unlock a"}, table.names)
+	assert.Empty(t, table.holders)
+}
+
+func Test_LockAll_Overlapping(t *testing.T) {
+	table := &lockTable{}
+	sets := [][]string{{"a", "b", "c"}, {"c", "d", "b"}}
+	wg := sync.WaitGroup{}
+	wg.Add(len(sets))
+	for _, names := range sets {
+		go func(names []string) {
+			defer wg.Done()
+			m := Mutex{DDBSession: table, RetryBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}.WithTimeout(10 * time.Second)
+			for i := 0; i < 20; i++ {
+				unlock, err := m.LockAll(names...)
+				if !assert.Nil(t, err) {
+					return
+				}
+				unlock()
+			}
+		}(names)
+	}
+	wg.Wait()
+	assert.Empty(t, table.holders)
+}
+
+func Test_LockAll_SharedMutex(t *testing.T) {
+	table := &lockTable{}
+	m := Mutex{DDBSession: table, RetryBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}.WithTimeout(10 * time.Second)
+	sets := [][]string{{"a", "b", "c"}, {"c", "d", "b"}, {"b", "e"}}
+	var mu sync.Mutex
+	holding := make(map[string]bool)
+	wg := sync.WaitGroup{}
+	wg.Add(len(sets))
+	for _, names := range sets {
+		go func(names []string) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				unlock, err := m.LockAll(names...)
+				if !assert.Nil(t, err) {
+					return
+				}
+				mu.Lock()
+				for _, name := range names {
+					assert.False(t, holding[name], name) // Held by one goroutine at a time
+					holding[name] = true
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				for _, name := range names {
+					holding[name] = false
+				}
+				mu.Unlock()
+				unlock()
+			}
+		}(names)
+	}
+	wg.Wait()
+	assert.Empty(t, table.holders)
+}
+
+func Test_LockAll(t *testing.T) {
+	TableName := fmt.Sprintf("Test-LockAll-%d", time.Now().Unix())
+	sets := [][]string{{"a", "b", "c"}, {"c", "d", "b"}}
+	wg := sync.WaitGroup{}
+	wg.Add(len(sets))
+	for _, names := range sets {
+		go func(names []string) {
+			defer wg.Done()
+			m := Mutex{DDBTableName: TableName}.WithTimeout(30 * time.Second)
+			for i := 0; i < 5; i++ {
+				unlock, err := m.LockAll(names...)
+				if !assert.Nil(t, err) {
+					return
+				}
+				unlock()
+			}
+		}(names)
+	}
+	wg.Wait()
+	DeleteTable(Mutex{DDBTableName: TableName})
+}
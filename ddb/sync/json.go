@@ -0,0 +1,28 @@
+package sync
+
+import "encoding/json"
+
+// GetValueJSON decodes the value of the Mutex as JSON into v. An empty value leaves v unchanged.
+// It returns an error if the value is not valid JSON for v.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueJSON(v interface{}) error {
+	if m.value == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(m.value), v)
+}
+
+// SetValueJSON encodes v as JSON and sets it as the value in the Mutex. It does not check if the Mutex was locked
+// beforehand. It does not write the value into the database. The value is written to the database during Unlock.
+//
+// The value is not changed if v cannot be encoded.
+func (m *Mutex) SetValueJSON(v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.value = string(value)
+	m.valueSet = true
+	return nil
+}
@@ -0,0 +1,27 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testState struct {
+	Leader string            `json:"leader"`
+	Term   int               `json:"term"`
+	Tags   map[string]string `json:"tags,omitempty"`
+}
+
+func Test_ValueJSON(t *testing.T) {
+	m := Mutex{}
+	var state testState
+	assert.Nil(t, m.GetValueJSON(&state))
+	assert.Equal(t, testState{}, state)
+	assert.Nil(t, m.SetValueJSON(testState{Leader: "node-1", Term: 3, Tags: map[string]string{"zone": "a"}}))
+	assert.Equal(t, `{"leader":"node-1","term":3,"tags":{"zone":"a"}}`, m.GetValueString())
+	assert.Nil(t, m.GetValueJSON(&state))
+	assert.Equal(t, testState{Leader: "node-1", Term: 3, Tags: map[string]string{"zone": "a"}}, state)
+	assert.NotNil(t, m.SetValueJSON(func() {}))
+	assert.Equal(t, `{"leader":"node-1","term":3,"tags":{"zone":"a"}}`, m.GetValueString())
+	m.SetValueString("{broken")
+	assert.NotNil(t, m.GetValueJSON(&state))
+}
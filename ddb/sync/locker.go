@@ -0,0 +1,7 @@
+package sync
+
+import "github.com/greg-szabo/dsync/dsync"
+
+// Mutex is the DynamoDB implementation of dsync.Locker. Code that only needs to lock and share a value can depend on
+// the interface, and the compiler checks that Mutex keeps implementing all of it.
+var _ dsync.Locker = (*Mutex)(nil)
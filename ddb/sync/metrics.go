@@ -0,0 +1,41 @@
+package sync
+
+import "time"
+
+// Metrics receives measurements from a Mutex. Implement it to export them to a metrics system, like Prometheus
+// counters and histograms. The methods are called synchronously and must be safe for concurrent use.
+type Metrics interface {
+	// IncContention is called when an attempt to lock fails because the lock is held by someone else.
+	IncContention()
+	// ObserveWait is called with the time Lock waited, when it acquired the lock.
+	ObserveWait(wait time.Duration)
+	// IncThrottle is called when DynamoDB throttled a request.
+	IncThrottle()
+	// IncAcquire is called when the lock is acquired.
+	IncAcquire()
+	// IncSteal is called when the lock is acquired by taking over the expired lock of another holder.
+	IncSteal()
+	// IncTimeout is called when Lock gives up because the lock was not acquired before the timeout.
+	IncTimeout()
+	// IncUnlock is called when the lock is unlocked.
+	IncUnlock()
+}
+
+// noMetrics is the Metrics used when none is set.
+type noMetrics struct{}
+
+func (noMetrics) IncContention()            {}
+func (noMetrics) ObserveWait(time.Duration) {}
+func (noMetrics) IncThrottle()              {}
+func (noMetrics) IncAcquire()               {}
+func (noMetrics) IncSteal()                 {}
+func (noMetrics) IncTimeout()               {}
+func (noMetrics) IncUnlock()                {}
+
+// metrics returns the Metrics of the Mutex.
+func (m *Mutex) metrics() Metrics {
+	if m.Metrics == nil {
+		return noMetrics{}
+	}
+	return m.Metrics
+}
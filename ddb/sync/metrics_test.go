@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	contention, throttle, acquire, steal, waits, timeouts, unlocks int64
+}
+
+func (t *testMetrics) IncContention()              { atomic.AddInt64(&t.contention, 1) }
+func (t *testMetrics) ObserveWait(_ time.Duration) { atomic.AddInt64(&t.waits, 1) }
+func (t *testMetrics) IncThrottle()                { atomic.AddInt64(&t.throttle, 1) }
+func (t *testMetrics) IncAcquire()                 { atomic.AddInt64(&t.acquire, 1) }
+func (t *testMetrics) IncSteal()                   { atomic.AddInt64(&t.steal, 1) }
+func (t *testMetrics) IncTimeout()                 { atomic.AddInt64(&t.timeouts, 1) }
+func (t *testMetrics) IncUnlock()                  { atomic.AddInt64(&t.unlocks, 1) }
+
+func Test_Metrics(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-Metrics-%d", time.Now().Unix())
+	metrics := &testMetrics{}
+	m := Mutex{DDBTableName: TableName, Expiry: expiry, Metrics: metrics}.WithTimeout(time.Second)
+	n := Mutex{DDBTableName: TableName, Expiry: expiry, Metrics: metrics}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	assert.NotPanics(t, m.Lock)
+	assert.Panics(t, n.Lock)
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	assert.True(t, metrics.contention > 0)
+	assert.Equal(t, int64(3), metrics.acquire)
+	assert.Equal(t, int64(3), metrics.waits)
+	assert.Equal(t, int64(1), metrics.steal)
+	assert.Equal(t, int64(1), metrics.timeouts)
+	assert.Equal(t, int64(2), metrics.unlocks)
+	DeleteTable(m)
+}
+
+func Test_NoMetrics(t *testing.T) {
+	m := Mutex{}
+	assert.Equal(t, noMetrics{}, m.metrics())
+	metrics := &testMetrics{}
+	m.Metrics = metrics
+	assert.Equal(t, metrics, m.metrics())
+}
+
+func Test_Metrics_Offline(t *testing.T) {
+	metrics := &testMetrics{}
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	m := Mutex{DDBSession: held, Metrics: metrics}.WithTimeout(50 * time.Millisecond)
+	assert.Equal(t, ErrLockTimeout, m.LockWithError())
+	assert.Equal(t, int64(1), metrics.timeouts)
+	assert.Equal(t, int64(held.writes), metrics.contention)
+	m.DDBSession = &recordingWrites{}
+	assert.Nil(t, m.UnlockWithError())
+	assert.Equal(t, int64(1), metrics.unlocks)
+}
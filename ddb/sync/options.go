@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"time"
+)
+
+// An Option configures a Mutex created with NewMutex.
+type Option func(m *Mutex)
+
+// NewMutex returns a Mutex for the lock with the given name, configured by opts. It is equivalent to setting the
+// fields of a Mutex literal, which remains supported; fields without an Option can be set on the returned Mutex
+// before its first use.
+func NewMutex(name string, opts ...Option) *Mutex {
+	m := &Mutex{Name: name}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// WithRegion sets the AWS Region of the DynamoDB table, see AWSRegion.
+func WithRegion(region string) Option {
+	return func(m *Mutex) {
+		m.AWSRegion = region
+	}
+}
+
+// WithTable sets the name of the DynamoDB table, see DDBTableName.
+func WithTable(table string) Option {
+	return func(m *Mutex) {
+		m.DDBTableName = table
+	}
+}
+
+// WithExpiry sets the time after which a locked Mutex is considered abandoned, see Expiry.
+func WithExpiry(expiry time.Duration) Option {
+	return func(m *Mutex) {
+		m.Expiry = expiry
+	}
+}
+
+// WithTimeout sets the timeout of Lock, like the WithTimeout method of the Mutex. Set it to 0 for no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *Mutex) {
+		m.timeout = timeout
+		m.timeoutSet = true
+	}
+}
+
+// WithSession sets the AWS session the DynamoDB client is created from, see AWSSession.
+func WithSession(s *session.Session) Option {
+	return func(m *Mutex) {
+		m.AWSSession = s
+	}
+}
@@ -0,0 +1,22 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_NewMutex(t *testing.T) {
+	s := &session.Session{}
+	m := NewMutex("jobs", WithRegion("eu-west-1"), WithTable("Jobs"), WithExpiry(time.Minute), WithTimeout(0), WithSession(s))
+	assert.Equal(t, "jobs", m.Name)
+	assert.Equal(t, "eu-west-1", m.AWSRegion)
+	assert.Equal(t, "Jobs", m.DDBTableName)
+	assert.Equal(t, time.Minute, m.Expiry)
+	assert.Equal(t, time.Duration(0), m.GetTimeout())
+	assert.True(t, m.timeoutSet) // No timeout, instead of the default
+	assert.Equal(t, s, m.AWSSession)
+	n := NewMutex("jobs")
+	assert.Equal(t, &Mutex{Name: "jobs"}, n)
+}
@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ownedItem is a DynamoDB client holding a lock item of the given LockerID and OwnerID. Writes conditional on the
+// holder fail for other holders.
+type ownedItem struct {
+	activeTable
+	id    string
+	owner string
+}
+
+func (o *ownedItem) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if input.ConditionExpression != nil && strings.Contains(*input.ConditionExpression, "#owner = :owner") &&
+		(*input.ExpressionAttributeValues[":id"].N != o.id || *input.ExpressionAttributeValues[":owner"].S != o.owner) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func Test_OwnerID_Default(t *testing.T) {
+	m := Mutex{DDBSession: activeTable{}}
+	n := Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Nil(t, n.initialization())
+	hostname, _ := os.Hostname()
+	prefix := fmt.Sprintf("%s:%d:", hostname, os.Getpid())
+	assert.True(t, strings.HasPrefix(m.owner, prefix))
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), strings.TrimPrefix(m.owner, prefix))
+	assert.NotEqual(t, m.owner, n.owner)
+	o := Mutex{DDBSession: activeTable{}, OwnerID: "worker-1"}
+	assert.Nil(t, o.initialization())
+	assert.Equal(t, "worker-1", o.owner)
+}
+
+func Test_OwnerID_ForeignCannotUnlock(t *testing.T) {
+	item := &ownedItem{id: "42", owner: "a"}
+	m := Mutex{DDBSession: item, OwnerID: "a"}
+	foreign := Mutex{DDBSession: item, OwnerID: "b"}
+	m.id, foreign.id = 42, 42 // Colliding LockerIDs
+	assert.Nil(t, m.initialization())
+	assert.Nil(t, foreign.initialization())
+	m.locked, foreign.locked = true, true
+	assert.Equal(t, ErrFencedOut, foreign.UnlockWithError())
+	assert.Nil(t, m.UnlockWithError())
+}
+
+func Test_OwnerID(t *testing.T) {
+	TableName := fmt.Sprintf("Test-OwnerID-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, OwnerID: "a"}
+	foreign := Mutex{DDBTableName: TableName, OwnerID: "b"}
+	m.id, foreign.id = 42, 42 // Colliding LockerIDs
+	assert.NotPanics(t, m.Lock)
+	acquired, err := foreign.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	foreign.locked, foreign.fence = true, m.fence
+	assert.Equal(t, ErrFencedOut, foreign.UnlockWithError())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
@@ -0,0 +1,40 @@
+package sync
+
+import (
+	"log"
+	"os"
+)
+
+// A FailureMode defines how Lock and Unlock handle errors, as they cannot return them.
+type FailureMode int
+
+const (
+	// Panic with the error. This is the default.
+	Panic FailureMode = iota
+	// LogAndContinue logs the error and returns. The caller cannot tell that Lock or Unlock failed, so use it
+	// only as a migration aid towards the methods that return errors, like TryLock and TryUnlock.
+	LogAndContinue
+	// LogAndExit logs the error and exits the process with status 1.
+	LogAndExit
+)
+
+// PanicMode controls how Lock and Unlock handle errors, for all Mutexes. Errors are logged with the Logger of the
+// Mutex, or with the standard logger if it has none. Set it before using any Mutex.
+var PanicMode = Panic
+
+// fail handles an error of Lock or Unlock according to PanicMode.
+func (m *Mutex) fail(err error) {
+	switch PanicMode {
+	case LogAndContinue, LogAndExit:
+		if m.Logger != nil {
+			m.Logger.Printf("lock %s: %v", m.Name, err)
+		} else {
+			log.Printf("lock %s: %v", m.Name, err)
+		}
+		if PanicMode == LogAndExit {
+			os.Exit(1)
+		}
+	default:
+		panic(err)
+	}
+}
@@ -0,0 +1,19 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_PanicMode(t *testing.T) {
+	defer func() { PanicMode = Panic }()
+	logger := &testLogger{}
+	m := Mutex{Name: "failing", Logger: logger}
+	assert.Panics(t, func() { m.fail(ErrAlreadyHeld) })
+	PanicMode = LogAndContinue
+	assert.NotPanics(t, func() { m.fail(ErrAlreadyHeld) })
+	assert.Equal(t, []string{"lock failing: lock is already held by this Mutex"}, logger.lines)
+	n := Mutex{DDBTableName: "my locks!"} // Invalid, fails before reaching DynamoDB
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+}
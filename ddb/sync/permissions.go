@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+)
+
+// errCodeAccessDenied is the error code returned by DynamoDB when IAM denies an action.
+const errCodeAccessDenied = "AccessDeniedException"
+
+// VerifyPermissions checks that the AWS credentials of the Mutex allow the DynamoDB actions needed to lock and
+// unlock, and reports the missing ones. It is meant for deployment validation, before the first Lock.
+//
+// The checks do not change the table: writes are probed with conditions that never match. The table must exist,
+// as dynamodb:CreateTable cannot be checked without creating a table.
+func (m *Mutex) VerifyPermissions() (err error) {
+	err = m.configure()
+	if err != nil {
+		return
+	}
+	var missing []string
+	check := func(action string, err error) error {
+		switch {
+		case err == nil, isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException):
+			return nil
+		case isErrCode(err, errCodeAccessDenied):
+			missing = append(missing, action)
+			return nil
+		case isErrCode(err, dynamodb.ErrCodeResourceNotFoundException):
+			return fmt.Errorf("table %s does not exist, permissions cannot be verified", m.DDBTableName)
+		default:
+			return fmt.Errorf("could not verify %s: %w", action, err)
+		}
+	}
+
+	_, err = m.DDBSession.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(m.DDBTableName),
+	})
+	if err = check("dynamodb:DescribeTable", err); err != nil {
+		return
+	}
+
+	_, err = m.DDBSession.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            m.key(m.Name),
+		TableName:      &m.DDBTableName,
+	})
+	if err = check("dynamodb:GetItem", err); err != nil {
+		return
+	}
+
+	_, err = m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("attribute_exists(#name) AND attribute_not_exists(#name)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name":  aws.String(m.KeyAttributeName),
+			"#probe": aws.String("PermissionProbe"),
+		},
+		Key:              m.key(m.Name),
+		UpdateExpression: aws.String("REMOVE #probe"),
+		TableName:        &m.DDBTableName,
+	})
+	if err = check("dynamodb:UpdateItem", err); err != nil {
+		return
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions on table %s: %s", m.DDBTableName, strings.Join(missing, ", "))
+	}
+	return
+}
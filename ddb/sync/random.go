@@ -0,0 +1,57 @@
+package sync
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// random is the source of the jitter of the package. It is seeded once per process, instead of reseeding the
+// global source of math/rand on every initialization, which other packages may depend on.
+var (
+	randomOnce sync.Once
+	randomMu   sync.Mutex
+	random     *rand.Rand
+)
+
+// randomInt63n returns a random number in [0, n) for jitter, like rand.Int63n. It is safe for concurrent use.
+func randomInt63n(n int64) int64 {
+	randomOnce.Do(func() {
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	})
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Int63n(n)
+}
+
+// newLockerID returns a random, positive LockerID from crypto/rand. Unlike a pseudo-random source seeded with the
+// time, it does not repeat for Mutexes initialized at the same time, which would let both hold the lock.
+func newLockerID() (id int64, err error) {
+	b := make([]byte, 8)
+	for id == 0 {
+		if _, err = cryptorand.Read(b); err != nil {
+			return
+		}
+		id = int64(binary.BigEndian.Uint64(b) >> 1)
+	}
+	return
+}
+
+// newOwnerID returns the default OwnerID of a Mutex: the hostname, the process ID and a random version 4 UUID.
+func newOwnerID() (owner string, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	u := make([]byte, 16)
+	if _, err = cryptorand.Read(u); err != nil {
+		return
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%s:%d:%x-%x-%x-%x-%x", hostname, os.Getpid(), u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
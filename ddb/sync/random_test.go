@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func Test_NewLockerID_Unique(t *testing.T) {
+	goroutines, perGoroutine := 50, 200
+	ids := make(map[int64]bool)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				id, err := newLockerID()
+				assert.Nil(t, err)
+				assert.True(t, id > 0)
+				mu.Lock()
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, goroutines*perGoroutine, len(ids))
+}
+
+func Test_LockerID_Initialization(t *testing.T) {
+	m := Mutex{DDBSession: activeTable{}}
+	n := Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Nil(t, n.initialization())
+	assert.NotEqual(t, m.id, n.id)
+	id := m.id
+	assert.Nil(t, m.Close())
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, id, m.id) // Kept when initialized again
+}
+
+func Test_RandomInt63n(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		n := randomInt63n(10)
+		assert.True(t, n >= 0 && n < 10)
+	}
+}
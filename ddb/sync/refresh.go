@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshUntil renews the lease of the held lock every interval until ctx is done, so the lock does not expire
+// while the work it protects is in progress. It refreshes in the background and returns immediately.
+//
+// Refreshing also stops when the lock is unlocked or was taken over. Failed refreshes are reported to
+// OnRefreshError and the Logger, and other failures are retried at the next interval.
+func (m *Mutex) RefreshUntil(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := m.Refresh()
+			if err == nil {
+				continue
+			}
+			if err == ErrNotOwner {
+				// Unlocked before ctx was done
+				return
+			}
+			m.logf("could not refresh lock %s: %v", m.Name, err)
+			if m.OnRefreshError != nil {
+				m.OnRefreshError(err)
+			}
+			if err == ErrFencedOut {
+				return
+			}
+		}
+	}()
+}
+
+// KeepAlive renews the lease of the held lock in the background every third of Expiry, until the Mutex is unlocked
+// or the returned function is called. It lets a short Expiry recover crashed holders quickly, while critical
+// sections take as long as they need. See AutoRenew to keep every acquisition alive.
+func (m *Mutex) KeepAlive() (stop func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.startRenewal()
+	cancel := m.renewal
+	return func() {
+		if cancel != nil {
+			cancel()
+		}
+	}
+}
+
+// startRenewal starts renewing the lease of the lock until it is released.
+// Nothing is started if the Mutex does not expire.
+func (m *Mutex) startRenewal() {
+	m.stopRenewal()
+	if m.Expiry <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.renewal = cancel
+	m.RefreshUntil(ctx, m.Expiry/3)
+}
+
+// stopRenewal stops renewing the lease of the lock, if it is renewed.
+func (m *Mutex) stopRenewal() {
+	if m.renewal != nil {
+		m.renewal()
+		m.renewal = nil
+	}
+}
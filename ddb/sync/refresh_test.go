@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_RefreshUntil(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-RefreshUntil-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Expiry: expiry}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.RefreshUntil(ctx, expiry/4)
+	time.Sleep(2 * expiry)
+	assert.Panics(t, n.Lock) // Still refreshed
+	cancel()
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock) // Expired after the context was cancelled
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_KeepAlive(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-KeepAlive-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Expiry: expiry, AutoRenew: true}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	time.Sleep(2 * expiry)
+	assert.Panics(t, n.Lock) // Renewed automatically
+	assert.NotPanics(t, m.Unlock)
+	assert.Nil(t, m.renewal)
+
+	assert.NotPanics(t, n.Lock)
+	stop := n.KeepAlive()
+	time.Sleep(2 * expiry)
+	assert.True(t, n.held())
+	stop()
+	time.Sleep(expiry)
+	assert.NotPanics(t, m.Lock) // Expired after the renewal stopped
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_KeepAlive_Offline(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes, Expiry: 300 * time.Millisecond, AutoRenew: true}
+	assert.Nil(t, m.LockWithError())
+	// The state read by held and Close is written by the renewal in the meantime, run with -race
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		assert.True(t, m.held())
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, m.Close())
+	assert.False(t, m.held())
+}
@@ -0,0 +1,133 @@
+package sync
+
+import "sync"
+
+// MaxHeldLocks limits the number of locks held at the same time by all Mutexes of the process. Acquiring one more
+// fails with ErrTooManyLocksHeld, which turns locks that are never released into loud errors. Zero means no limit.
+// Locks that expired, or outlived StaleGracePeriod without an Expiry, do not count, even if they were not released,
+// as other processes can take them over. Set it before using any Mutex.
+var MaxHeldLocks int
+
+// registry keeps track of the Mutexes of the process that hold their lock.
+var registry = struct {
+	sync.Mutex
+	held     map[*Mutex]struct{}
+	reserved int
+	// closed is set when the locks are released on a signal, to stop new acquisitions
+	closed bool
+}{
+	held: make(map[*Mutex]struct{}),
+}
+
+// register records that m holds its lock. It returns false without recording it if the registry is closed.
+func register(m *Mutex) bool {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.closed {
+		return false
+	}
+	registry.held[m] = struct{}{}
+	return true
+}
+
+// closeRegistry stops new acquisitions: reserving and registering locks fail from then on, so ReleaseAll releases
+// every lock that is held once it returns.
+func closeRegistry() {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.closed = true
+}
+
+// unregister records that m released its lock.
+func unregister(m *Mutex) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.held, m)
+}
+
+// reserveHeldLock reserves a slot for one more lock, so concurrent acquisitions cannot exceed MaxHeldLocks together.
+// It returns ErrTooManyLocksHeld if the held and reserved locks already reach the limit, and ErrShuttingDown if the
+// registry is closed. The caller must call release once the lock is registered or the acquisition failed.
+func reserveHeldLock() (release func(), err error) {
+	var lapsed map[*Mutex]bool
+	if MaxHeldLocks > 0 {
+		lapsed = lapsedLocks()
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.closed {
+		return nil, ErrShuttingDown
+	}
+	if MaxHeldLocks > 0 && countHeld(lapsed)+registry.reserved >= MaxHeldLocks {
+		return nil, ErrTooManyLocksHeld
+	}
+	registry.reserved++
+	return func() {
+		registry.Lock()
+		defer registry.Unlock()
+		registry.reserved--
+	}, nil
+}
+
+// HeldLocks returns the number of locks held by all Mutexes of the process, not counting those that expired, like
+// MaxHeldLocks.
+func HeldLocks() int {
+	lapsed := lapsedLocks()
+	registry.Lock()
+	defer registry.Unlock()
+	return countHeld(lapsed)
+}
+
+// lapsedLocks returns the registered Mutexes whose locks can be taken over by now. It must be called without the
+// lock of the registry, as it takes the lock of each Mutex, which is held while registering.
+func lapsedLocks() map[*Mutex]bool {
+	registry.Lock()
+	mutexes := make([]*Mutex, 0, len(registry.held))
+	for m := range registry.held {
+		mutexes = append(mutexes, m)
+	}
+	registry.Unlock()
+	lapsed := make(map[*Mutex]bool)
+	for _, m := range mutexes {
+		if m.lapsed() {
+			lapsed[m] = true
+		}
+	}
+	return lapsed
+}
+
+// countHeld returns the number of registered locks that are not in lapsed. The lock of the registry must be held.
+func countHeld(lapsed map[*Mutex]bool) (count int) {
+	for m := range registry.held {
+		if !lapsed[m] {
+			count++
+		}
+	}
+	return
+}
+
+// lapsed reports whether the lock of the Mutex can be taken over by now: it expired or, without an Expiry, it is
+// older than StaleGracePeriod.
+func (m *Mutex) lapsed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return (m.Expiry > 0 || m.StaleGracePeriod > 0) && m.lastWrite < m.takeoverBefore()
+}
+
+// ReleaseAll unlocks every lock held by the Mutexes of the process, writing their values into the database, for
+// example at shutdown. It unlocks all of them and returns the first error encountered.
+// The Mutexes should not be in use by other goroutines meanwhile.
+func ReleaseAll() (err error) {
+	registry.Lock()
+	var mutexes []*Mutex
+	for m := range registry.held {
+		mutexes = append(mutexes, m)
+	}
+	registry.Unlock()
+	for _, m := range mutexes {
+		if unlockErr := m.unlock(); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MaxHeldLocks(t *testing.T) {
+	defer func() { MaxHeldLocks = 0 }()
+	held := HeldLocks()
+	a, b := &Mutex{}, &Mutex{}
+	assert.True(t, register(a))
+	assert.True(t, register(a))
+	assert.Equal(t, held+1, HeldLocks())
+	release, err := reserveHeldLock()
+	assert.Nil(t, err)
+	release()
+	MaxHeldLocks = held + 2
+	release, err = reserveHeldLock()
+	assert.Nil(t, err)
+	release()
+	assert.True(t, register(b))
+	_, err = reserveHeldLock()
+	assert.Equal(t, ErrTooManyLocksHeld, err)
+	a.released()
+	b.released()
+	assert.Equal(t, held, HeldLocks())
+}
+
+func Test_MaxHeldLocks_Expired(t *testing.T) {
+	defer func() { MaxHeldLocks = 0 }()
+	held := HeldLocks()
+	old := time.Now().Add(-time.Minute).UnixNano()
+	expired := &Mutex{Expiry: time.Second, locked: true, lastWrite: old}
+	stale := &Mutex{StaleGracePeriod: time.Second, locked: true, lastWrite: old}
+	live := &Mutex{Expiry: time.Hour, locked: true, lastWrite: old}
+	forever := &Mutex{locked: true, lastWrite: old}
+	for _, m := range []*Mutex{expired, stale, live, forever} {
+		assert.True(t, register(m))
+		defer unregister(m)
+	}
+	assert.Equal(t, held+2, HeldLocks()) // Only live and forever still count
+	MaxHeldLocks = held + 3
+	release, err := reserveHeldLock()
+	assert.Nil(t, err)
+	defer release()
+	_, err = reserveHeldLock()
+	assert.Equal(t, ErrTooManyLocksHeld, err)
+}
+
+func Test_MaxHeldLocks_Concurrent(t *testing.T) {
+	defer func() { MaxHeldLocks = 0 }()
+	MaxHeldLocks = HeldLocks() + 3
+	var wg sync.WaitGroup
+	var reserved, failed int32
+	start := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := reserveHeldLock(); err != nil {
+				assert.Equal(t, ErrTooManyLocksHeld, err)
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+			atomic.AddInt32(&reserved, 1)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int32(3), reserved)
+	assert.Equal(t, int32(7), failed)
+	registry.Lock()
+	registry.reserved = 0
+	registry.Unlock()
+}
+
+func Test_ReleaseAll(t *testing.T) {
+	defer func() { MaxHeldLocks = 0 }()
+	TableName := fmt.Sprintf("Test-ReleaseAll-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Name: "a"}
+	n := Mutex{DDBTableName: TableName, Name: "b"}
+	assert.NotPanics(t, m.Lock)
+	MaxHeldLocks = HeldLocks()
+	assert.Equal(t, ErrTooManyLocksHeld, n.lock())
+	MaxHeldLocks = 0
+	assert.NotPanics(t, n.Lock)
+	n.SetValueString("released")
+	assert.Nil(t, ReleaseAll())
+	assert.Equal(t, 0, HeldLocks())
+	assert.Equal(t, "released", n.LockAndGetValueString())
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
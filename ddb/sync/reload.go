@@ -0,0 +1,30 @@
+package sync
+
+// Reload reads the value of the lock held by the Mutex from the table again, with a strongly consistent read, and
+// replaces the value of the Mutex with it. Long-lived holders use it to pick up changes written to the lock item
+// by other means than the lock, for example by an administrator. Nothing is written: the lease is not renewed and
+// LastWrite does not change, see Refresh for that. It can be called any number of times while the lock is held.
+//
+// It returns ErrNotOwner if the Mutex is not locked, and ErrFencedOut if the lock expired and was taken over.
+func (m *Mutex) Reload() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locked {
+		return ErrNotOwner
+	}
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	if !m.holds(item) {
+		m.released()
+		return ErrFencedOut
+	}
+	if m.VerifyIntegrity {
+		if err = verifyChecksum(item, m.ValueAttributeName); err != nil {
+			return
+		}
+	}
+	m.item = item
+	return m.loadValue(item)
+}
@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// storedItem is a DynamoDB client whose GetItem returns item.
+type storedItem struct {
+	recordingWrites
+	item  map[string]*dynamodb.AttributeValue
+	reads []*dynamodb.GetItemInput
+}
+
+func (s *storedItem) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	s.reads = append(s.reads, input)
+	return &dynamodb.GetItemOutput{Item: s.item}, nil
+}
+
+func Test_Reload(t *testing.T) {
+	table := &storedItem{recordingWrites: recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+		"Value":            {S: aws.String("old")},
+	}}}
+	m := Mutex{Name: "reloaded", DDBSession: table}
+	assert.Equal(t, ErrNotOwner, m.Reload())
+	assert.Nil(t, m.LockWithError())
+	assert.Equal(t, "old", m.GetValueString())
+	lastWrite := m.lastWrite
+	table.input = nil
+	table.item = map[string]*dynamodb.AttributeValue{
+		"Name":     {S: aws.String("reloaded")},
+		"LockerID": {N: aws.String(strconv.FormatInt(m.id, 10))},
+		"OwnerID":  {S: aws.String(m.owner)},
+		"Fence":    {N: aws.String("1")},
+		"Value":    {S: aws.String("new")},
+	}
+	assert.Nil(t, m.Reload())
+	assert.Nil(t, m.Reload()) // Reentrant
+	assert.Equal(t, "new", m.GetValueString())
+	assert.Nil(t, table.input) // Read-only
+	assert.Equal(t, lastWrite, m.lastWrite)
+	assert.Len(t, table.reads, 2)
+	assert.True(t, *table.reads[0].ConsistentRead)
+	assert.Equal(t, "reloaded", *table.reads[0].Key["Name"].S)
+
+	// Taken over
+	table.item["Fence"] = &dynamodb.AttributeValue{N: aws.String("2")}
+	assert.Equal(t, ErrFencedOut, m.Reload())
+	assert.False(t, m.locked)
+	assert.Equal(t, "new", m.GetValueString())
+}
@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+)
+
+// An RWMutex is a reader/writer lock stored in the same table as a Mutex. The lock can be held by any number of
+// readers or by a single writer.
+//
+// The lock item stores the number of readers, the LockerID of the writer holding the lock and the LockerID of the
+// writer waiting for it. A waiting writer stops new readers from locking, so writers are not starved by a steady
+// stream of readers; the writer then waits for the readers to drain.
+//
+// The lock item of an RWMutex must not be used by a Mutex with the same name. Expiry, the value and the other
+// options of the lock item of a Mutex do not apply: a reader or writer that never unlocks blocks the lock.
+type RWMutex struct {
+	base *Mutex
+}
+
+// NewRWMutex returns an RWMutex named like base, using its sessions, table and timeout. The base Mutex must not be
+// used directly after it is passed in.
+//
+// RLock and RUnlock can be called from several goroutines once the RWMutex is initialized by its first call.
+// Lock and Unlock should only be used by one goroutine at a time, as the writer is identified by the LockerID of
+// the RWMutex.
+func NewRWMutex(base *Mutex) *RWMutex {
+	return &RWMutex{base: base}
+}
+
+// RLock locks the RWMutex for reading. If a writer holds or waits for the lock, the calling goroutine blocks until
+// the writer unlocks it or the timeout period has been reached. Errors cause a panic, unless PanicMode says
+// otherwise.
+func (rw *RWMutex) RLock() {
+	if err := rw.rlock(); err != nil {
+		rw.base.fail(err)
+	}
+}
+
+// rlock implements RLock, returning an error instead of panicking.
+func (rw *RWMutex) rlock() (err error) {
+	err = rw.base.initialization()
+	if err != nil {
+		return
+	}
+	return rw.base.retryConditional(func() error {
+		_, err := rw.update("#readers=if_not_exists(#readers, :zero) + :one",
+			"( attribute_not_exists(#writer) OR #writer = :zero ) AND ( attribute_not_exists(#waiting) OR #waiting = :zero )")
+		return err
+	})
+}
+
+// RUnlock undoes a single RLock call. Errors cause a panic, unless PanicMode says otherwise: unlocking an RWMutex
+// that has no readers fails with ErrNotOwner.
+func (rw *RWMutex) RUnlock() {
+	if err := rw.runlock(); err != nil {
+		rw.base.fail(err)
+	}
+}
+
+// runlock implements RUnlock, returning an error instead of panicking.
+func (rw *RWMutex) runlock() (err error) {
+	err = rw.base.initialization()
+	if err != nil {
+		return
+	}
+	_, err = rw.update("#readers=#readers - :one", "#readers > :zero")
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return ErrNotOwner
+	}
+	return
+}
+
+// Lock locks the RWMutex for writing. If the lock is held by readers or another writer, the calling goroutine
+// blocks until it is available or the timeout period has been reached. Errors cause a panic, unless PanicMode says
+// otherwise.
+func (rw *RWMutex) Lock() {
+	if err := rw.lock(); err != nil {
+		rw.base.fail(err)
+	}
+}
+
+// lock implements Lock, returning an error instead of panicking.
+//
+// The writer first marks itself as waiting, which keeps new readers out, and then takes the lock once the readers
+// are gone. If it times out while waiting for the readers, the mark is removed again.
+func (rw *RWMutex) lock() (err error) {
+	err = rw.base.initialization()
+	if err != nil {
+		return
+	}
+	err = rw.base.retryConditional(func() error {
+		_, err := rw.update("#waiting=:id",
+			"( attribute_not_exists(#writer) OR #writer = :zero ) AND ( attribute_not_exists(#waiting) OR #waiting = :zero OR #waiting = :id )")
+		return err
+	})
+	if err != nil {
+		return
+	}
+	err = rw.base.retryConditional(func() error {
+		_, err := rw.update("#writer=:id, #waiting=:zero",
+			"#waiting = :id AND ( attribute_not_exists(#readers) OR #readers = :zero )")
+		return err
+	})
+	if err != nil {
+		if _, clearErr := rw.update("#waiting=:zero", "#waiting = :id"); clearErr != nil {
+			rw.base.logf("could not clear waiting writer of %s: %v", rw.base.Name, clearErr)
+		}
+	}
+	return
+}
+
+// Unlock unlocks the RWMutex for writing. Errors cause a panic, unless PanicMode says otherwise: unlocking an
+// RWMutex that is not locked for writing by this RWMutex fails with ErrNotOwner.
+func (rw *RWMutex) Unlock() {
+	if err := rw.unlock(); err != nil {
+		rw.base.fail(err)
+	}
+}
+
+// unlock implements Unlock, returning an error instead of panicking.
+func (rw *RWMutex) unlock() (err error) {
+	err = rw.base.initialization()
+	if err != nil {
+		return
+	}
+	_, err = rw.update("#writer=:zero", "#writer = :id")
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return ErrNotOwner
+	}
+	return
+}
+
+// Readers returns the number of readers holding the RWMutex.
+func (rw *RWMutex) Readers() (count int64, err error) {
+	err = rw.base.initialization()
+	if err != nil {
+		return
+	}
+	item, err := rw.base.getItem(rw.base.Name)
+	if err != nil || item == nil {
+		return
+	}
+	if readers := item["Readers"]; readers != nil && readers.N != nil {
+		count, err = strconv.ParseInt(*readers.N, 10, 64)
+	}
+	return
+}
+
+// update applies a SET expression to the lock item of the RWMutex if condition holds.
+func (rw *RWMutex) update(set string, condition string) (*dynamodb.UpdateItemOutput, error) {
+	return rw.base.setIf(set, condition, map[string]string{
+		"#readers": "Readers",
+		"#writer":  "Writer",
+		"#waiting": "WaitingWriter",
+	}, map[string]string{
+		":id":   strconv.FormatInt(rw.base.id, 10),
+		":zero": "0",
+		":one":  "1",
+	})
+}
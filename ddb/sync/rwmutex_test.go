@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RWMutex_ParallelCount(t *testing.T) {
+	TableName := fmt.Sprintf("Test-RWParallel-%d", time.Now().Unix())
+	thisMany := 5
+	var count int64
+	var readers, writers int32 // In the critical section
+	wg := sync.WaitGroup{}
+	wg.Add(2 * thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			writer := NewRWMutex(&Mutex{DDBTableName: TableName})
+			assert.NotPanics(t, writer.Lock)
+			assert.Equal(t, int32(1), atomic.AddInt32(&writers, 1))
+			assert.Equal(t, int32(0), atomic.LoadInt32(&readers))
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt64(&count, 1)
+			atomic.AddInt32(&writers, -1)
+			assert.NotPanics(t, writer.Unlock)
+		}()
+		go func() {
+			defer wg.Done()
+			reader := NewRWMutex(&Mutex{DDBTableName: TableName})
+			assert.NotPanics(t, reader.RLock)
+			atomic.AddInt32(&readers, 1)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&writers))
+			time.Sleep(10 * time.Millisecond)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&writers))
+			atomic.AddInt32(&readers, -1)
+			assert.NotPanics(t, reader.RUnlock)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(thisMany), atomic.LoadInt64(&count))
+	remaining, err := NewRWMutex(&Mutex{DDBTableName: TableName}).Readers()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), remaining)
+	DeleteTable(Mutex{DDBTableName: TableName})
+}
+
+func Test_RWMutex_WriterWaitsForReaders(t *testing.T) {
+	TableName := fmt.Sprintf("Test-RWWriterWaits-%d", time.Now().Unix())
+	reader := NewRWMutex(&Mutex{DDBTableName: TableName})
+	other := NewRWMutex(&Mutex{DDBTableName: TableName})
+	base := Mutex{DDBTableName: TableName}.WithTimeout(time.Second)
+	writer := NewRWMutex(&base)
+	assert.NotPanics(t, reader.RLock)
+	assert.NotPanics(t, other.RLock) // Readers share the lock
+	assert.Equal(t, ErrLockTimeout, writer.lock())
+	assert.NotPanics(t, reader.RUnlock)
+	assert.NotPanics(t, other.RUnlock)
+	assert.Equal(t, ErrNotOwner, reader.runlock())
+	assert.NotPanics(t, writer.Lock)
+	assert.Equal(t, ErrNotOwner, reader.unlock())
+	assert.NotPanics(t, writer.Unlock)
+	DeleteTable(base)
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+)
+
+// A Semaphore is a counting semaphore stored in the same table as a Mutex. Up to Limit holders can acquire it at
+// the same time, for example to limit the number of workers that use a resource.
+//
+// The lock item stores the number of holders. The lock item of a Semaphore must not be used by a Mutex or an
+// RWMutex with the same name. Expiry and the other options of the lock item of a Mutex do not apply: a holder that
+// never releases the Semaphore keeps its slot.
+type Semaphore struct {
+	// Maximum number of holders of the Semaphore. Lowering it does not affect the current holders.
+	Limit int
+
+	base *Mutex
+}
+
+// NewSemaphore returns a Semaphore with the given limit, named like base and using its sessions, table and timeout.
+// The base Mutex must not be used directly after it is passed in.
+func NewSemaphore(base *Mutex, limit int) *Semaphore {
+	return &Semaphore{
+		Limit: limit,
+		base:  base,
+	}
+}
+
+// Validate checks the configuration of the Semaphore. A Limit below one would never let anyone acquire it, so it is
+// rejected instead of blocking until the timeout. It is called by Acquire.
+func (s *Semaphore) Validate() error {
+	if s.Limit <= 0 {
+		return fmt.Errorf("invalid Limit: %d is not positive", s.Limit)
+	}
+	return nil
+}
+
+// Acquire acquires the Semaphore. If it already has Limit holders, the calling goroutine blocks until one of them
+// releases it or the timeout period has been reached, waiting between attempts like Lock. Errors cause a panic,
+// unless PanicMode says otherwise.
+func (s *Semaphore) Acquire() {
+	if err := s.acquire(); err != nil {
+		s.base.fail(err)
+	}
+}
+
+// acquire implements Acquire, returning an error instead of panicking.
+func (s *Semaphore) acquire() (err error) {
+	err = s.Validate()
+	if err != nil {
+		return
+	}
+	err = s.base.initialization()
+	if err != nil {
+		return
+	}
+	return s.base.retryConditional(func() error {
+		_, err := s.update("#holders=if_not_exists(#holders, :zero) + :one",
+			"attribute_not_exists(#holders) OR #holders < :limit")
+		return err
+	})
+}
+
+// Release releases the Semaphore. Errors cause a panic, unless PanicMode says otherwise: releasing a Semaphore
+// that has no holders fails with ErrNotOwner.
+func (s *Semaphore) Release() {
+	if err := s.release(); err != nil {
+		s.base.fail(err)
+	}
+}
+
+// release implements Release, returning an error instead of panicking.
+func (s *Semaphore) release() (err error) {
+	err = s.base.initialization()
+	if err != nil {
+		return
+	}
+	_, err = s.update("#holders=#holders - :one", "#holders > :zero")
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return ErrNotOwner
+	}
+	return
+}
+
+// Holders returns the number of holders of the Semaphore.
+func (s *Semaphore) Holders() (count int64, err error) {
+	err = s.base.initialization()
+	if err != nil {
+		return
+	}
+	item, err := s.base.getItem(s.base.Name)
+	if err != nil || item == nil {
+		return
+	}
+	if holders := item["Holders"]; holders != nil && holders.N != nil {
+		count, err = strconv.ParseInt(*holders.N, 10, 64)
+	}
+	return
+}
+
+// update applies a SET expression to the lock item of the Semaphore if condition holds.
+func (s *Semaphore) update(set string, condition string) (*dynamodb.UpdateItemOutput, error) {
+	return s.base.setIf(set, condition, map[string]string{
+		"#holders": "Holders",
+	}, map[string]string{
+		":limit": strconv.Itoa(s.Limit),
+		":zero":  "0",
+		":one":   "1",
+	})
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Semaphore_ParallelLimit(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Semaphore-%d", time.Now().Unix())
+	thisMany := 6
+	limit := 2
+	current, peak := 0, 0
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			s := NewSemaphore(&Mutex{DDBTableName: TableName}, limit)
+			assert.NotPanics(t, s.Acquire)
+			defer assert.NotPanics(t, s.Release)
+			mu.Lock()
+			current++
+			if current > peak {
+				peak = current
+			}
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			current--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.True(t, peak <= limit)
+	holders, err := NewSemaphore(&Mutex{DDBTableName: TableName}, limit).Holders()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), holders)
+	DeleteTable(Mutex{DDBTableName: TableName})
+}
+
+func Test_Semaphore_Timeout(t *testing.T) {
+	TableName := fmt.Sprintf("Test-SemaphoreTimeout-%d", time.Now().Unix())
+	base := Mutex{DDBTableName: TableName}.WithTimeout(time.Second)
+	s := NewSemaphore(&base, 1)
+	assert.NotPanics(t, s.Acquire)
+	assert.Equal(t, ErrLockTimeout, s.acquire())
+	s.Limit = 2
+	assert.NotPanics(t, s.Acquire)
+	assert.NotPanics(t, s.Release)
+	assert.NotPanics(t, s.Release)
+	assert.Equal(t, ErrNotOwner, s.release())
+	DeleteTable(base)
+}
+
+func Test_Semaphore_Validate(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		writes := &failingWrites{}
+		s := NewSemaphore(&Mutex{DDBSession: writes}, limit)
+		assert.EqualError(t, s.acquire(), fmt.Sprintf("invalid Limit: %d is not positive", limit))
+		assert.Equal(t, 0, writes.writes)
+	}
+	assert.Nil(t, NewSemaphore(&Mutex{}, 1).Validate())
+}
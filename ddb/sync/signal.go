@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ReleaseOnSignal unlocks the locks held by the Mutexes of the process, like ReleaseAll, when the process receives
+// one of the given signals, SIGINT and SIGTERM by default. Then the signal is raised again, so the process
+// terminates like it would have without the handler. Handlers of the application registered with signal.Notify
+// receive the signal twice. The returned function uninstalls the handler.
+//
+// Before releasing the locks, the handler stops new acquisitions: locking any Mutex fails with ErrShuttingDown from
+// then on, even if the process survives the signal. Holders are not waited for: goroutines still inside their
+// critical sections keep running after their locks are released, and their Unlock fails with ErrNotOwner.
+//
+// It is opt-in and best-effort: locks are not released if the process is killed with SIGKILL, crashes or loses
+// its network. Set Expiry for locks that must not leak.
+func ReleaseOnSignal(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	received := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(received, signals...)
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-received:
+			closeRegistry()
+			if err := ReleaseAll(); err != nil {
+				log.Printf("could not release locks on %v: %v", sig, err)
+			}
+			signal.Stop(received)
+			raise(sig)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(received)
+			close(done)
+		})
+	}
+}
+
+// raise sends sig to the process. It is a variable for tests.
+var raise = func(sig os.Signal) {
+	process, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = process.Signal(sig)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/signal"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ReleaseOnSignal(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer func(saved func(os.Signal)) { raise = saved }(raise)
+	raise = func(sig os.Signal) { raised <- sig }
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.Nil(t, m.LockWithError())
+	m.SetValueString("saved")
+	stop := ReleaseOnSignal(os.Interrupt)
+	defer stop()
+	process, err := os.FindProcess(os.Getpid())
+	assert.Nil(t, err)
+	assert.Nil(t, process.Signal(os.Interrupt))
+	select {
+	case sig := <-raised:
+		assert.Equal(t, os.Interrupt, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not handled")
+	}
+	assert.False(t, m.locked)
+	assert.Equal(t, "saved", *writes.input.ExpressionAttributeValues[":value"].S)
+	defer reopenRegistry()
+	writes.input = nil
+	n := Mutex{DDBSession: writes}
+	acquired, err := n.TryLock()
+	assert.Equal(t, ErrShuttingDown, err)
+	assert.False(t, acquired)
+	assert.Nil(t, writes.input) // Not attempted
+	// An acquisition that was in flight when the locks were released gives its lock up again
+	assert.Equal(t, ErrShuttingDown, n.acquired(writes.attributes))
+	assert.False(t, n.locked)
+	assert.Equal(t, "SET #id=:zero", *writes.input.UpdateExpression)
+	assert.Equal(t, 0, HeldLocks())
+}
+
+// reopenRegistry undoes closeRegistry, so the other tests can lock again.
+func reopenRegistry() {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.closed = false
+}
+
+func Test_ReleaseOnSignal_Stop(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer func(saved func(os.Signal)) { raise = saved }(raise)
+	raise = func(sig os.Signal) { raised <- sig }
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.Nil(t, m.LockWithError())
+	defer m.ForceUnlock()
+	stop := ReleaseOnSignal(os.Interrupt)
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			stop() // Safe to call concurrently and more than once
+		}()
+	}
+	wg.Wait()
+	// Keep the signal from terminating the test, now that the handler is uninstalled
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, os.Interrupt)
+	defer signal.Stop(guard)
+	process, err := os.FindProcess(os.Getpid())
+	assert.Nil(t, err)
+	assert.Nil(t, process.Signal(os.Interrupt))
+	<-guard
+	select {
+	case <-raised:
+		t.Fatal("signal was handled after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.True(t, m.locked) // Not released
+}
@@ -3,304 +3,2054 @@
 package sync
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"math/rand"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"math"
+	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// defaultRegions holds the region used in each AWS partition if AWSRegion is not set.
+var defaultRegions = map[string]string{
+	endpoints.AwsPartitionID:      "us-east-1",
+	endpoints.AwsCnPartitionID:    "cn-north-1",
+	endpoints.AwsUsGovPartitionID: "us-gov-west-1",
+}
+
+var (
+	// validTableName matches the table names allowed by DynamoDB.
+	validTableName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+	// invalidTableNameCharacters matches the characters that are not allowed in DynamoDB table names.
+	invalidTableNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+// sanitizeTableName turns name into a valid DynamoDB table name.
+func sanitizeTableName(name string) string {
+	name = invalidTableNameCharacters.ReplaceAllString(name, "_")
+	for len(name) < 3 {
+		name = name + "_"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// expandTableName substitutes the tenant and the lock name into a table name template.
+func expandTableName(template, tenant, name string) string {
+	return strings.NewReplacer("{tenant}", tenant, "{name}", name).Replace(template)
+}
+
+const (
+	// maxRetryDelay is the longest delay between two attempts to lock a Mutex, before MaxBackoff is applied.
+	maxRetryDelay = 100 * time.Millisecond
+	// maxRetryBackoff caps the exponential backoff between two attempts to lock, if MaxBackoff is not set.
+	maxRetryBackoff = 500 * time.Millisecond
+	// maxTablePollInterval caps the backoff between DescribeTable calls while waiting for a table.
+	maxTablePollInterval = 5 * time.Second
+)
+
+// A Logger receives debug messages from the Mutex. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 // A Mutex is a mutual exclusion lock.
 // This version of a Mutex has extra properties for the AWS session and DynamoDB session details.
 type Mutex struct {
 	// Name of the Mutex used in the DynamoDB table.
 	Name string
 
+	// Value stored in the database when the lock item is created, so a new lock has a known value when it is first
+	// locked. Without it the value is empty until the first Unlock.
+	DefaultValue string
+
+	// Client token identifying one logical acquisition. A lock held under the same RequestID, for example by an
+	// earlier attempt whose response was lost to a network error, is adopted instead of being treated as a conflict.
+	RequestID string
+
+	// Identifier of the holder, stored with the lock in the OwnerID attribute next to the random LockerID. Locks are
+	// only acquired and unlocked by a Mutex with both, so two Mutexes cannot both hold a lock even if their LockerIDs
+	// collide. Set it to a stable identifier of the process, like a pod name, to recognize holders in the table; it
+	// must be unique among the processes using the lock. Default: hostname, process ID and a random UUID.
+	OwnerID string
+
 	// Amount of time before a locked mutex is considered abandoned.
 	Expiry time.Duration
+	// Extra time added to Expiry before an abandoned lock is taken over, to allow for clock skew between machines.
+	// Expiry is compared against timestamps written by other machines, so a fast clock could take over a lock that
+	// is still in use. A larger tolerance makes this less likely, at the cost of recovering abandoned locks later.
+	ExpirySkewTolerance time.Duration
+	// Without an Expiry, a lock is never taken over, so a crashed holder blocks it forever. StaleGracePeriod is a
+	// last resort for that case: a lock that was not written for this long is taken over anyway. Set it well above
+	// the longest time the lock can legitimately be held. It is ignored if Expiry is set.
+	StaleGracePeriod time.Duration
+	// Warn this long before the lease of a held lock expires, so work that outlives Expiry is noticed before the lock
+	// is taken over. Refresh restarts the countdown. The warning goes to OnExpiryWarning and the Logger.
+	// Zero disables the warning.
+	ExpiryWarning time.Duration
+	// Called with the name of the lock and the remaining time when ExpiryWarning fires. Optional.
+	OnExpiryWarning func(name string, remaining time.Duration)
+	// Renew the lease of the lock in the background while it is held, as if KeepAlive was called after every
+	// acquisition. It has no effect without Expiry.
+	AutoRenew bool
+	// Called when RefreshUntil fails to renew the lease of the lock. Optional.
+	OnRefreshError func(err error)
+
+	// Number of failed attempts to lock the Mutex kept in the lock item for auditing, see FailedAttempts. Lock records
+	// one entry per call, not per retry. Zero disables recording.
+	AuditFailedAttempts int
+
+	// Amount of time after Unlock during which this Mutex cannot lock the same item again, so others get a chance
+	// to take the lock in tight lock and unlock loops.
+	Cooldown time.Duration
+
+	// Make Unlock succeed if the table does not exist anymore, since there is no lock left to release.
+	// Useful in shutdown code that may run after the infrastructure was torn down.
+	UnlockMissingTable bool
+
+	// Report the current holder of the lock when the lock is contended: it is logged on the first conflict and
+	// included in the error when Lock times out. It costs a consistent read each time.
+	// (DynamoDB can return the conflicting item of a failed UpdateItem, but the AWS SDK in use does not support it.)
+	DiagnoseConflicts bool
+
+	// Count the processes waiting for the lock in the database. See WaiterCount.
+	TrackWaiters bool
+
+	// Maximum random delay before the first attempt to lock the Mutex. It spreads out the first attempts of
+	// processes that start at the same time, like a fleet of cron jobs. The delay is not part of the timeout.
+	InitialJitter time.Duration
+	// Maximum number of attempts to lock the Mutex, each one an UpdateItem call, to bound the cost of waiting for a
+	// contended or throttled lock. Lock fails with ErrMaxAttempts after that many attempts, or with ErrLockTimeout
+	// if the timeout elapses first. Zero means no limit besides the timeout.
+	MaxAttempts int
+	// Maximum delay between two attempts to lock the Mutex. Zero means no limit besides the built-in delay.
+	// The delay never exceeds the time left until the timeout.
+	MaxBackoff time.Duration
+	// Base delay of the exponential backoff between two attempts to lock the Mutex. The delay doubles with every
+	// failed attempt, up to MaxBackoff or 500 milliseconds, and a random delay up to that bound is used (full
+	// jitter). Zero keeps the flat random delay up to 100 milliseconds, which suits locks with few contenders.
+	RetryBackoff time.Duration
+	// Disable all randomness in the timing of Lock: InitialJitter is ignored and attempts are exactly 100
+	// milliseconds apart, or MaxBackoff if that is shorter. With RetryBackoff, the delay is exactly the doubled
+	// backoff. Meant for tests that assert timing.
+	DisableJitter bool
+	// Admit waiters in the order they called Lock, instead of whoever retries first, so no waiter is starved under
+	// contention. Lock takes a ticket from the lock item before its first attempt, which costs one more write, and
+	// TryLock only succeeds if nobody is queued. A lock left free for a few retry delays, because the next waiter gave
+	// up or died, is open to all waiters again. All Mutexes of a lock should set it; others do not queue.
+	Fair bool
 
 	// The AWS Region where the DynamoDB table resides.
 	AWSRegion string
+	// The AWS partition of the region, like "aws-us-gov" or "aws-cn". Endpoints are resolved within this partition,
+	// and the default region is the partition's main region. Default: "aws".
+	AWSPartition string
+	// Custom DynamoDB endpoint URL, like "http://localhost:8000" for DynamoDB Local or LocalStack. It is only used
+	// if the Mutex creates the AWS session, sessions passed in through AWSSession are not changed.
+	Endpoint string
 	// The AWS Session handle
 	AWSSession *session.Session
 	// Used to ignore AWS_* environment variables in favor of IAM policy permissions.
 	// Use only if both are set up. By default, environment variables take precedence.
 	IgnoreEnvVars bool
-	// The DynamoDB Session handle
-	DDBSession *dynamodb.DynamoDB
+	// Named profile of the shared AWS configuration (~/.aws/credentials and ~/.aws/config) used for the AWS session.
+	// A selected profile takes precedence over AWS_* environment variables, and AWSRegion over the region of the
+	// profile. It is only used if the Mutex creates the AWS session.
+	AWSProfile string
+	// ARN of an IAM role assumed to access DynamoDB, for example in another account. The credentials of the AWS
+	// session, from environment variables, AWSProfile or the default chain, are used to assume the role.
+	// It is only used if the Mutex creates the DynamoDB session.
+	RoleARN string
+	// External ID passed when assuming RoleARN, if the trust policy of the role requires one. Optional.
+	ExternalID string
+	// The DynamoDB Session handle. Any implementation of the DynamoDB API can be used, like a DAX client or a mock.
+	DDBSession dynamodbiface.DynamoDBAPI
+	// The DynamoDB Session handle used for reads that do not need to be strongly consistent, like WaiterCount and
+	// FailedAttempts, for example a client of a replica or of DAX. Locking, unlocking and the reads they depend on
+	// always use DDBSession. Default: DDBSession.
+	DDBReadSession dynamodbiface.DynamoDBAPI
 	// The DynamoDB Table name
 	DDBTableName string
+	// Name of the hash key attribute of the table. Set it to keep the locks in an existing table whose hash key is
+	// named differently; the key must be a string attribute. Default: Name
+	KeyAttributeName string
+	// Names of the attributes holding the value, the time of the last write and the LockerID of the holder. Set them
+	// to share a table whose schema is dictated by another system. Default: Value, LastWrite and LockerID
+	ValueAttributeName     string
+	LastWriteAttributeName string
+	LockerIDAttributeName  string
+	// Template of the DynamoDB table name, like "Locks-{tenant}". "{tenant}" is replaced with Tenant and "{name}"
+	// with Name when the Mutex is initialized. If set, it takes precedence over DDBTableName.
+	TableNameTemplate string
+	// Tenant substituted into TableNameTemplate.
+	Tenant string
+	// Replace invalid characters in DDBTableName with '_' and fix its length, instead of returning an error.
+	SanitizeTableName bool
+	// Billing mode of the table, if the Mutex creates it: "PROVISIONED" or "PAY_PER_REQUEST" for on-demand capacity,
+	// which has no throughput limit for bursts of lockers. Default: "PROVISIONED".
+	BillingMode string
+	// Provisioned read and write capacity units of the table, if the Mutex creates it. Raise them for locks with
+	// many parallel lockers. They cannot be set with the "PAY_PER_REQUEST" billing mode. Default: 5 each.
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+	// Initial interval between DescribeTable calls while waiting for a new table to become active. The interval
+	// grows by half after every call, up to 5 seconds. Default: 100 milliseconds.
+	TablePollInterval time.Duration
+	// Maximum time to wait for the table to become active during initialization, for example while it is created.
+	// Initialization fails with ErrTableNotReady afterwards. Default: 1 minute.
+	TableReadyTimeout time.Duration
+	// Encrypt the table with a KMS key, if the Mutex creates it. Without SSEKMSKeyID, the AWS managed key of DynamoDB
+	// is used. Tables are always encrypted at rest: by default with a key owned by AWS, which does not show up in KMS.
+	SSEEnabled bool
+	// ID, ARN or alias of the customer managed KMS key to encrypt the table with, if the Mutex creates it. Setting it
+	// implies SSEEnabled.
+	SSEKMSKeyID string
+	// Tags of the table, like cost allocation or ownership tags, if the Mutex creates it. The tags of an existing table
+	// are not changed.
+	Tags map[string]string
+	// Enable DynamoDB Time to Live on the table, so lock items that were not written for TTLRetention are deleted by
+	// DynamoDB, together with their value. Locks write the ExpiresAt attribute, in seconds since the epoch, when they
+	// are locked, refreshed and unlocked. TTL is turned on for the table during initialization.
+	//
+	// A deleted lock item takes its fencing token with it: the next acquisition starts counting from the beginning
+	// again, so FencingToken only grows between deletions. Do not enable TTL for locks whose fencing tokens are
+	// checked by other systems, unless they forget the tokens they have seen within TTLRetention.
+	EnableTTL bool
+	// Time after the last write of a lock item at which DynamoDB may delete it, if EnableTTL is set. It must be longer
+	// than Expiry, and than the longest hold of a lock that is not refreshed. Default: 24 hours.
+	TTLRetention time.Duration
+
+	// Logger for debug messages, like attempts to lock, retries, takeovers of expired locks and unlocks.
+	// Nothing is logged if it is nil.
+	Logger Logger
+	// Metrics receives measurements of the Mutex, like lock contention and wait times. Optional.
+	Metrics Metrics
+	// Clock tells the time to the Mutex, see Clock. All Mutexes of a lock should use the same time. Default: the
+	// system clock.
+	Clock Clock
+	// Log the consumed capacity of every DynamoDB operation issued while locking and unlocking.
+	// Used for capacity tuning, requires a Logger.
+	LogConsumedCapacity bool
+
+	// Values longer than this many bytes are gzip compressed before they are written to the database and
+	// decompressed transparently when the Mutex is locked. Zero disables compression.
+	CompressThreshold int
+	// Write a checksum of the value next to it on Unlock, and check it on Lock. If the value was changed outside of
+	// this package, locking fails with ErrIntegrityMismatch and the lock is freed again, without changing the value.
+	// Repair the value with SetValueString and ForceUnlock. Values written without a checksum are not checked.
+	VerifyIntegrity bool
+
+	initialized bool
+	ownSession  bool
+	locked      bool
+
+	timeout    time.Duration
+	timeoutSet bool
+
+	value     string
+	bytes     []byte
+	id        int64
+	owner     string
+	fence     uint64
+	lastWrite int64
+	// Last write of the lock item before it was acquired, in Unix nanoseconds.
+	previousWrite int64
+	// Whether the value was set since the lock was last released, so ForceUnlock writes it.
+	valueSet bool
+	// Set while the Mutex is being locked, so goroutines sharing it cannot lock it at the same time.
+	acquiring bool
+	// Ticket in the queue of a Fair lock, while Lock waits.
+	ticket int64
+
+	// The lock item, as returned by the database when the lock was acquired.
+	item map[string]*dynamodb.AttributeValue
+
+	// Fires ExpiryWarning while the lock is held.
+	watchdog *time.Timer
+	// Stops renewing the lease of the lock, see KeepAlive.
+	renewal context.CancelFunc
+	// Locks of LockKey, by name.
+	keys *MutexGroup
+
+	// Guards the lock state against concurrent changes by RefreshUntil.
+	mu sync.Mutex
+}
+
+func (m *Mutex) initialization() (err error) {
+
+	if m.initialized {
+		return
+	}
+
+	err = m.configure()
+	if err != nil {
+		return
+	}
+
+	// Check table existence and create if not exists
+	err = m.ensureTable()
+	if err != nil {
+		return
+	}
+	if m.EnableTTL {
+		err = m.enableTTL()
+		if err != nil {
+			return
+		}
+	}
+
+	if m.id == 0 {
+		m.id, err = newLockerID()
+		if err != nil {
+			return
+		}
+	}
+	if m.owner == "" {
+		m.owner = m.OwnerID
+	}
+	if m.owner == "" {
+		m.owner, err = newOwnerID()
+		if err != nil {
+			return
+		}
+	}
+
+	if !m.timeoutSet {
+		m.timeout = 5 * time.Second
+	}
+	m.initialized = true
+	return
+
+}
+
+// configure applies the defaults, validates the configuration and creates the sessions of the Mutex, without
+// accessing DynamoDB. It can be called more than once.
+func (m *Mutex) configure() (err error) {
+
+	err = m.Validate()
+	if err != nil {
+		return
+	}
+
+	// Defaults
+	var partition *endpoints.Partition
+	if m.AWSPartition != "" {
+		for _, p := range endpoints.DefaultPartitions() {
+			if p.ID() == m.AWSPartition {
+				partition = &p
+				break
+			}
+		}
+		if partition == nil {
+			return fmt.Errorf("unknown AWS partition: %s", m.AWSPartition)
+		}
+	}
+	if m.AWSRegion == "" {
+		m.AWSRegion = defaultRegions[m.AWSPartition]
+		if m.AWSRegion == "" {
+			m.AWSRegion = "us-east-1"
+		}
+	}
+	if m.Name == "" {
+		m.Name = "Lock"
+	}
+	if m.TableNameTemplate != "" {
+		m.DDBTableName = expandTableName(m.TableNameTemplate, m.Tenant, m.Name)
+	}
+	if m.DDBTableName == "" {
+		m.DDBTableName = "Locks"
+	}
+	if m.KeyAttributeName == "" {
+		m.KeyAttributeName = "Name"
+	}
+	if m.TablePollInterval <= 0 {
+		m.TablePollInterval = 100 * time.Millisecond
+	}
+	if m.TableReadyTimeout <= 0 {
+		m.TableReadyTimeout = time.Minute
+	}
+	if m.BillingMode != dynamodb.BillingModePayPerRequest {
+		if m.ReadCapacityUnits <= 0 {
+			m.ReadCapacityUnits = 5
+		}
+		if m.WriteCapacityUnits <= 0 {
+			m.WriteCapacityUnits = 5
+		}
+	}
+	if m.TTLRetention <= 0 {
+		m.TTLRetention = defaultTTLRetention
+	}
+	switch m.BillingMode {
+	case "", dynamodb.BillingModeProvisioned, dynamodb.BillingModePayPerRequest:
+	default:
+		return fmt.Errorf("unknown billing mode: %s", m.BillingMode)
+	}
+
+	if !validTableName.MatchString(m.DDBTableName) {
+		if !m.SanitizeTableName {
+			return fmt.Errorf("invalid table name %q: use 3-255 characters of a-z, A-Z, 0-9, '_', '-' and '.'", m.DDBTableName)
+		}
+		m.DDBTableName = sanitizeTableName(m.DDBTableName)
+	}
+
+	if len(m.KeyAttributeName) > maxKeyAttributeNameLength || itemAttributes[m.KeyAttributeName] {
+		return fmt.Errorf("invalid key attribute name %q: use at most %d bytes, other than the attributes of a lock item", m.KeyAttributeName, maxKeyAttributeNameLength)
+	}
+	err = m.configureAttributeNames()
+	if err != nil {
+		return
+	}
+
+	if m.GetValueString() == "" {
+		m.value = "0"
+	}
+
+	// Create AWS session, if it does not exist
+	if m.AWSSession == nil {
+		cfg := aws.Config{
+			Region: aws.String(m.AWSRegion),
+			// A client of its own, so Close does not close the idle connections of http.DefaultClient
+			HTTPClient: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		}
+		if partition != nil {
+			cfg.EndpointResolver = partition
+		}
+		if m.Endpoint != "" {
+			cfg.Endpoint = aws.String(m.Endpoint)
+		}
+		// Use IAM or environment variables credential, unless a profile is selected
+		if !m.IgnoreEnvVars && m.AWSProfile == "" &&
+			((os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "") ||
+				(os.Getenv("AWS_ACCESS_KEY") != "" && os.Getenv("AWS_SECRET_KEY") != "")) {
+			cfg.Credentials = credentials.NewEnvCredentials()
+		}
+		options := session.Options{Config: cfg}
+		if m.AWSProfile != "" {
+			options.Profile = m.AWSProfile
+			options.SharedConfigState = session.SharedConfigEnable
+		}
+		m.AWSSession, err = session.NewSessionWithOptions(options)
+		if err != nil {
+			return fmt.Errorf("could not create AWS session: %w", err)
+		}
+		m.ownSession = true
+	}
+	// Create DynamoDB session, if it does not exist
+	if m.DDBSession == nil {
+		var cfgs []*aws.Config
+		if m.RoleARN != "" {
+			cfgs = append(cfgs, &aws.Config{
+				Credentials: stscreds.NewCredentials(m.AWSSession, m.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+					if m.ExternalID != "" {
+						p.ExternalID = aws.String(m.ExternalID)
+					}
+				}),
+			})
+		}
+		m.DDBSession = dynamodb.New(m.AWSSession, cfgs...)
+	}
+	return
+}
+
+// ensureTable creates the table of the Mutex if it does not exist, and waits until it is active.
+//
+// Processes starting at the same time may all try to create the table. Only one of them succeeds, the others
+// wait for the table created by the winner.
+func (m *Mutex) ensureTable() (err error) {
+	_, err = m.describeTable()
+	if err == nil {
+		return m.waitForTable()
+	}
+	if !isErrCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return fmt.Errorf("could not access table: %w", err)
+	}
+
+	_, err = m.DDBSession.CreateTable(m.createTableInput())
+	if err != nil && !isErrCode(err, dynamodb.ErrCodeResourceInUseException) {
+		return fmt.Errorf("sync table not created: %w", err)
+	}
+	return m.waitForTable()
+}
+
+// describeTable describes the table of the Mutex. Throttled calls are retried with backoff, for at most
+// TableReadyTimeout, as the table may be described by many processes starting at the same time.
+func (m *Mutex) describeTable() (output *dynamodb.DescribeTableOutput, err error) {
+	deadline := time.Now().Add(m.TableReadyTimeout)
+	interval := m.TablePollInterval
+	for {
+		output, err = m.DDBSession.DescribeTable(&dynamodb.DescribeTableInput{
+			TableName: aws.String(m.DDBTableName),
+		})
+		if !isThrottled(err) || time.Now().After(deadline) {
+			return
+		}
+		m.metrics().IncThrottle()
+		m.logf("describing table %s was throttled, retrying in %v", m.DDBTableName, interval)
+		time.Sleep(interval)
+		interval = nextTablePollInterval(interval)
+	}
+}
+
+// createTableInput describes the table of the Mutex for CreateTable.
+func (m *Mutex) createTableInput() *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String(m.KeyAttributeName),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(m.KeyAttributeName),
+				KeyType:       aws.String(dynamodb.KeyTypeHash),
+			},
+		},
+		TableName: aws.String(m.DDBTableName),
+	}
+	// DynamoDB rejects a provisioned throughput for on-demand tables
+	if m.BillingMode == dynamodb.BillingModePayPerRequest {
+		input.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+	} else {
+		input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(m.ReadCapacityUnits),
+			WriteCapacityUnits: aws.Int64(m.WriteCapacityUnits),
+		}
+	}
+	if m.SSEEnabled || m.SSEKMSKeyID != "" {
+		input.SSESpecification = &dynamodb.SSESpecification{
+			Enabled: aws.Bool(true),
+		}
+		if m.SSEKMSKeyID != "" {
+			input.SSESpecification.SSEType = aws.String(dynamodb.SSETypeKms)
+			input.SSESpecification.KMSMasterKeyId = aws.String(m.SSEKMSKeyID)
+		}
+	}
+	// Sorted, so the request is the same for every process
+	keys := make([]string, 0, len(m.Tags))
+	for key := range m.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		input.Tags = append(input.Tags, &dynamodb.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(m.Tags[key]),
+		})
+	}
+	return input
+}
+
+// waitForTable waits until the table of the Mutex can be used, for at most TableReadyTimeout.
+//
+// It polls DescribeTable instead of using the WaitUntilTableExists waiter of the SDK, which does not accept tables
+// that are being updated and does not report throttling to Metrics.
+func (m *Mutex) waitForTable() error {
+	deadline := time.Now().Add(m.TableReadyTimeout)
+	interval := m.TablePollInterval
+	for {
+		tableDescription, err := m.describeTable()
+		switch {
+		case isErrCode(err, dynamodb.ErrCodeResourceNotFoundException):
+			// A table created by another process may not be visible yet
+		case err != nil:
+			return fmt.Errorf("could not access table: %w", err)
+		case *tableDescription.Table.TableStatus == dynamodb.TableStatusActive,
+			*tableDescription.Table.TableStatus == dynamodb.TableStatusUpdating:
+			return nil
+		case *tableDescription.Table.TableStatus != dynamodb.TableStatusCreating:
+			return fmt.Errorf("error activating table. Table status: %v", *tableDescription.Table.TableStatus)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: table %s did not become active in %v", ErrTableNotReady, m.DDBTableName, m.TableReadyTimeout)
+		}
+		time.Sleep(interval)
+		interval = nextTablePollInterval(interval)
+	}
+}
+
+// nextTablePollInterval returns the interval after interval, growing it by half up to maxTablePollInterval.
+func nextTablePollInterval(interval time.Duration) time.Duration {
+	interval += interval / 2
+	if interval > maxTablePollInterval {
+		interval = maxTablePollInterval
+	}
+	return interval
+}
+
+func (m *Mutex) tryLock() (err error) {
+
+	// Create lock in database
+	lastWrite := m.clock().Now().UnixNano()
+	condition := "attribute_not_exists(#name) OR attribute_not_exists(#id) OR #id = :zero OR ( #id = :id AND #owner = :owner )"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":owner": {
+			S: aws.String(m.owner),
+		},
+		":lastwrite": {
+			N: aws.String(strconv.FormatInt(lastWrite, 10)),
+		},
+		":id": {
+			N: aws.String(strconv.FormatInt(m.id, 10)),
+		},
+		":zero": {
+			N: aws.String("0"),
+		},
+		":one": {
+			N: aws.String("1"),
+		},
+	}
+
+	if m.Expiry > 0 || m.StaleGracePeriod > 0 {
+		condition = condition + " OR ( NOT ( #id = :id AND #owner = :owner ) AND ( attribute_not_exists(#lastwrite) OR #lastwrite < :nowminusexpiry ) )"
+		expressionAttributeValues[":nowminusexpiry"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(m.takeoverBefore(), 10)),
+		}
+	}
+
+	expressionAttributeNames := map[string]*string{
+		"#name":      aws.String(m.KeyAttributeName),
+		"#lastwrite": aws.String(m.LastWriteAttributeName),
+		"#id":        aws.String(m.LockerIDAttributeName),
+		"#owner":     aws.String(ownerAttribute),
+		"#fence":     aws.String("Fence"),
+	}
+
+	// The previous holder is recorded to tell takeovers of expired locks apart.
+	set := []string{"#lastwrite=:lastwrite", "#previd=if_not_exists(#id, :zero)", "#id=:id", "#owner=:owner", "#prevwrite=if_not_exists(#lastwrite, :zero)"}
+	expressionAttributeNames["#previd"] = aws.String("PreviousLockerID")
+	expressionAttributeNames["#prevwrite"] = aws.String("PreviousLastWrite")
+	if m.EnableTTL {
+		set = append(set, "#expiresat=:expiresat")
+		expressionAttributeNames["#expiresat"] = aws.String(ttlAttribute)
+		expressionAttributeValues[":expiresat"] = m.ttlExpiry(lastWrite)
+	}
+	if m.DefaultValue != "" {
+		set = append(set, "#value=if_not_exists(#value, :default)")
+		expressionAttributeNames["#value"] = aws.String(m.ValueAttributeName)
+		expressionAttributeValues[":default"] = &dynamodb.AttributeValue{
+			S: aws.String(m.DefaultValue),
+		}
+	}
+
+	var remove []string
+	expressionAttributeNames["#requestid"] = aws.String("RequestID")
+	if m.RequestID != "" {
+		set = append(set, "#requestid=:requestid")
+		expressionAttributeValues[":requestid"] = &dynamodb.AttributeValue{
+			S: aws.String(m.RequestID),
+		}
+	} else {
+		remove = append(remove, "#requestid")
+	}
+
+	condition = "( " + condition + " ) AND attribute_not_exists(#retired) AND ( attribute_not_exists(#notbefore) OR #notbefore <= :lastwrite )"
+	expressionAttributeNames["#retired"] = aws.String("Retired")
+	expressionAttributeNames["#notbefore"] = aws.String("NotBefore")
+
+	if m.Fair {
+		fair, admit := m.fairCondition(lastWrite, expressionAttributeNames, expressionAttributeValues)
+		condition = "( " + condition + " ) AND ( " + fair + " )"
+		set = append(set, admit...)
+	}
+
+	if m.Cooldown > 0 {
+		condition = "( " + condition + " ) AND ( attribute_not_exists(#cooldownuntil) OR #releasedby <> :id OR #cooldownuntil < :lastwrite )"
+		expressionAttributeNames["#releasedby"] = aws.String("ReleasedBy")
+		expressionAttributeNames["#cooldownuntil"] = aws.String("CooldownUntil")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		Key:                       m.key(m.Name),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+		UpdateExpression:          aws.String(updateExpression(set, remove) + " ADD #fence :one"),
+		TableName:                 &m.DDBTableName,
+	}
+
+	result, err := m.updateItem("tryLock", input)
+
+	if err != nil {
+		if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			if m.RequestID != "" {
+				adopted, adoptErr := m.adoptRequest()
+				if adoptErr != nil || adopted {
+					return adoptErr
+				}
+			}
+			m.metrics().IncContention()
+		}
+		return
+	}
+
+	m.metrics().IncAcquire()
+	if previous := *result.Attributes["PreviousLockerID"].N; previous != "0" && previous != strconv.FormatInt(m.id, 10) {
+		m.metrics().IncSteal()
+		m.logf("took over lock %s from %s", m.Name, previous)
+	}
+
+	return m.acquired(result.Attributes)
+}
+
+// adoptRequest takes over a lock that is already held under m.RequestID, so a retried acquisition succeeds without
+// writing a new lock. The LockerID and fencing token of the earlier attempt are kept.
+func (m *Mutex) adoptRequest() (adopted bool, err error) {
+	item, err := m.getItem(m.Name)
+	if err != nil || item == nil {
+		return
+	}
+	if requestID := item["RequestID"]; requestID == nil || requestID.S == nil || *requestID.S != m.RequestID {
+		return
+	}
+	id := item[m.LockerIDAttributeName]
+	if id == nil || id.N == nil || *id.N == "0" || isRetired(item) {
+		return
+	}
+	m.id, err = strconv.ParseInt(*id.N, 10, 64)
+	if err != nil {
+		return
+	}
+	if owner := item[ownerAttribute]; owner != nil && owner.S != nil {
+		m.owner = *owner.S
+	}
+	err = m.acquired(item)
+	adopted = err == nil
+	return
+}
+
+// acquired records the state of a lock item that this Mutex now holds.
+func (m *Mutex) acquired(item map[string]*dynamodb.AttributeValue) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.VerifyIntegrity {
+		if err = verifyChecksum(item, m.ValueAttributeName); err != nil {
+			// Nobody would unlock the lock otherwise, as the caller learns it was not acquired
+			if releaseErr := m.abandon(item); releaseErr != nil {
+				m.logf("could not unlock %s after its integrity check failed: %v", m.Name, releaseErr)
+			}
+			return
+		}
+	}
+	if !register(m) {
+		// The locks are being released on a signal, which would miss this one
+		if releaseErr := m.abandon(item); releaseErr != nil {
+			m.logf("could not unlock %s while releasing all locks: %v", m.Name, releaseErr)
+		}
+		return ErrShuttingDown
+	}
+	m.locked = true
+	m.item = item
+	m.startWatchdog()
+	if m.AutoRenew {
+		m.startRenewal()
+	}
+	m.fence, err = strconv.ParseUint(*item["Fence"].N, 10, 64)
+	if err != nil {
+		return
+	}
+	m.lastWrite, err = strconv.ParseInt(*item[m.LastWriteAttributeName].N, 10, 64)
+	if err != nil {
+		return
+	}
+	m.previousWrite = 0
+	if attribute, ok := item["PreviousLastWrite"]; ok && attribute.N != nil {
+		m.previousWrite, err = strconv.ParseInt(*attribute.N, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	return m.loadValue(item)
+}
+
+// loadValue sets the value of the Mutex from a lock item. A string value that is not in the item is left alone, a
+// binary value that is not in the item was removed.
+func (m *Mutex) loadValue(item map[string]*dynamodb.AttributeValue) (err error) {
+	value, ok, err := decodeValue(item, m.ValueAttributeName)
+	if err != nil {
+		return
+	}
+	if ok {
+		m.SetValueString(value)
+	}
+	m.bytes = nil
+	if attribute, ok := item[bytesAttribute]; ok {
+		m.bytes = attribute.B
+	}
+	return
+}
+
+// abandon frees a lock item that the Mutex just acquired, without writing its value, if it still holds it with the
+// fencing token of the item.
+func (m *Mutex) abandon(item map[string]*dynamodb.AttributeValue) (err error) {
+	_, err = m.updateItem("abandon", &dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#id = :id AND #owner = :owner AND #fence = :fence"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":    aws.String(m.LockerIDAttributeName),
+			"#owner": aws.String(ownerAttribute),
+			"#fence": aws.String("Fence"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				N: aws.String(strconv.FormatInt(m.id, 10)),
+			},
+			":owner": {
+				S: aws.String(m.owner),
+			},
+			":zero": {
+				N: aws.String("0"),
+			},
+			":fence": item["Fence"],
+		},
+		Key:              m.key(m.Name),
+		UpdateExpression: aws.String("SET #id=:zero"),
+		TableName:        &m.DDBTableName,
+	})
+	return
+}
+
+func (m *Mutex) tryUnlock() (err error) {
+	return m.writeUnlock(false, nil)
+}
+
+// writeUnlock writes the value of the Mutex into the lock item and frees the lock. Unless force is set, the write
+// is conditional on the Mutex holding the lock, with the fencing token it acquired it with. If expected is set, the
+// write is also conditional on the stored value being expected. A forced unlock by a Mutex that neither holds the
+// lock nor had its value set only frees the lock, leaving the stored value alone.
+func (m *Mutex) writeUnlock(force bool, expected *string) (err error) {
+	writeValue := !force || m.locked || m.valueSet
+
+	value, encoding, err := m.encodeValue(m.GetValueString())
+	if err != nil {
+		return
+	}
+
+	lastWrite := m.clock().Now().UnixNano()
+	condition := "attribute_not_exists(#name) OR ( #id = :id AND #owner = :owner AND #fence = :fence )"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":owner": {
+			S: aws.String(m.owner),
+		},
+		":lastwrite": {
+			N: aws.String(strconv.FormatInt(lastWrite, 10)),
+		},
+		":id": {
+			N: aws.String(strconv.FormatInt(m.id, 10)),
+		},
+		":zero": {
+			N: aws.String("0"),
+		},
+		":fence": {
+			N: aws.String(strconv.FormatUint(m.fence, 10)),
+		},
+	}
+
+	expressionAttributeNames := map[string]*string{
+		"#name":      aws.String(m.KeyAttributeName),
+		"#lastwrite": aws.String(m.LastWriteAttributeName),
+		"#id":        aws.String(m.LockerIDAttributeName),
+		"#owner":     aws.String(ownerAttribute),
+		"#fence":     aws.String("Fence"),
+	}
+
+	set := []string{"#lastwrite=:lastwrite", "#id=:zero"}
+	remove := []string{"#requestid"}
+	expressionAttributeNames["#requestid"] = aws.String("RequestID")
+	if writeValue {
+		set = append(set, "#value=:value")
+		expressionAttributeNames["#value"] = aws.String(m.ValueAttributeName)
+		expressionAttributeNames["#encoding"] = aws.String("Encoding")
+		expressionAttributeNames["#checksum"] = aws.String("Checksum")
+		expressionAttributeValues[":value"] = &dynamodb.AttributeValue{
+			S: aws.String(value),
+		}
+		if m.VerifyIntegrity {
+			set = append(set, "#checksum=:checksum")
+			expressionAttributeValues[":checksum"] = &dynamodb.AttributeValue{
+				S: aws.String(checksum(value)),
+			}
+		} else {
+			remove = append(remove, "#checksum")
+		}
+		if encoding != "" {
+			set = append(set, "#encoding=:encoding")
+			expressionAttributeValues[":encoding"] = &dynamodb.AttributeValue{
+				S: aws.String(encoding),
+			}
+		} else {
+			remove = append(remove, "#encoding")
+		}
+
+		if len(value)+len(m.bytes) > maxValueSize {
+			return ErrValueTooLarge
+		}
+		set, remove = m.setBytes(set, remove, expressionAttributeNames, expressionAttributeValues)
+	}
+
+	if m.EnableTTL {
+		set = append(set, "#expiresat=:expiresat")
+		expressionAttributeNames["#expiresat"] = aws.String(ttlAttribute)
+		expressionAttributeValues[":expiresat"] = m.ttlExpiry(lastWrite)
+	}
+
+	if m.Cooldown > 0 && !force {
+		set = append(set, "#releasedby=:id", "#cooldownuntil=:cooldownuntil")
+		expressionAttributeNames["#releasedby"] = aws.String("ReleasedBy")
+		expressionAttributeNames["#cooldownuntil"] = aws.String("CooldownUntil")
+		expressionAttributeValues[":cooldownuntil"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(m.clock().Now().UnixNano()+m.Cooldown.Nanoseconds(), 10)),
+		}
+	}
+
+	if expected != nil {
+		// The stored value may be compressed or not, depending on the CompressThreshold of the Mutex that wrote it
+		compressed, err := compress(*expected)
+		if err != nil {
+			return err
+		}
+		valueCondition := "( #value = :expected AND attribute_not_exists(#encoding) ) OR ( #value = :expectedgzip AND #encoding = :gzip )"
+		// A lock item without a value reads as "0", the initial value of a Mutex
+		if *expected == "0" {
+			valueCondition += " OR attribute_not_exists(#value)"
+		}
+		condition = "( " + condition + " ) AND ( " + valueCondition + " )"
+		expressionAttributeValues[":expected"] = &dynamodb.AttributeValue{
+			S: aws.String(*expected),
+		}
+		expressionAttributeValues[":expectedgzip"] = &dynamodb.AttributeValue{
+			S: aws.String(compressed),
+		}
+		expressionAttributeValues[":gzip"] = &dynamodb.AttributeValue{
+			S: aws.String(encodingGzip),
+		}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		Key:                       m.key(m.Name),
+		UpdateExpression:          aws.String(updateExpression(set, remove)),
+		TableName:                 &m.DDBTableName,
+	}
+
+	op := "tryUnlock"
+	if force {
+		// Only the condition uses these
+		op = "forceUnlock"
+		input.ConditionExpression = nil
+		delete(expressionAttributeNames, "#name")
+		delete(expressionAttributeNames, "#owner")
+		delete(expressionAttributeNames, "#fence")
+		delete(expressionAttributeValues, ":owner")
+		delete(expressionAttributeValues, ":fence")
+		delete(expressionAttributeValues, ":id")
+	}
+
+	_, err = m.updateItem(op, input)
+	if err == nil {
+		m.released()
+	}
+
+	return
+}
+
+// key returns the primary key of the lock item with the given name.
+func (m *Mutex) key(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		m.KeyAttributeName: {
+			S: aws.String(name),
+		},
+	}
+}
+
+// updateExpression builds an update expression from SET and REMOVE actions.
+func updateExpression(set []string, remove []string) string {
+	expression := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		expression = expression + " REMOVE " + strings.Join(remove, ", ")
+	}
+	return expression
+}
+
+// updateItem issues an UpdateItem call on behalf of op and logs its consumed capacity.
+//
+// If the call fails because the AWS credentials expired, the credentials are refreshed and the call is retried once.
+// Providers that can renew credentials, like an assumed role, recover this way. If the credentials are still
+// expired, the returned error wraps ErrCredentialsExpired.
+func (m *Mutex) updateItem(op string, input *dynamodb.UpdateItemInput) (result *dynamodb.UpdateItemOutput, err error) {
+	if m.LogConsumedCapacity {
+		input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	}
+	result, err = m.DDBSession.UpdateItem(input)
+	if isThrottled(err) {
+		m.metrics().IncThrottle()
+	}
+	if isCredentialsExpired(err) {
+		m.logf("op=%s credentials expired, refreshing", op)
+		m.expireCredentials()
+		result, err = m.DDBSession.UpdateItem(input)
+		if isCredentialsExpired(err) {
+			err = &credentialsExpiredError{err: err}
+		}
+	}
+	m.logCapacity(op, result, err)
+	return
+}
+
+// logf writes a debug message to the Logger, if one is set.
+func (m *Mutex) logf(format string, v ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, v...)
+	}
+}
+
+// logCapacity logs the consumed capacity of an UpdateItem call, if LogConsumedCapacity is set.
+//
+// DynamoDB does not report the consumed capacity of a failed conditional write, so it is logged as "-".
+func (m *Mutex) logCapacity(op string, output *dynamodb.UpdateItemOutput, err error) {
+	if !m.LogConsumedCapacity {
+		return
+	}
+	wcu := "-"
+	if output != nil && output.ConsumedCapacity != nil && output.ConsumedCapacity.CapacityUnits != nil {
+		wcu = strconv.FormatFloat(*output.ConsumedCapacity.CapacityUnits, 'f', 1, 64)
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+		if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			result = "conflict"
+		}
+	}
+	m.logf("op=%s wcu=%s result=%s", op, wcu, result)
+}
+
+// isThrottled reports whether err was caused by DynamoDB throttling a request.
+func isThrottled(err error) bool {
+	return isErrCode(err, dynamodb.ErrCodeProvisionedThroughputExceededException) ||
+		isErrCode(err, dynamodb.ErrCodeRequestLimitExceeded) ||
+		isErrCode(err, "ThrottlingException")
+}
+
+// isCredentialsExpired reports whether err was caused by expired AWS credentials.
+func isCredentialsExpired(err error) bool {
+	return isErrCode(err, "ExpiredTokenException") || isErrCode(err, "ExpiredToken")
+}
+
+// isErrCode reports whether err is, or wraps, an AWS error with the given code.
+func isErrCode(err error, code string) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == code
+}
+
+// expiredBefore returns the time, in nanoseconds, before which a lock is considered abandoned if it was not written.
+func (m *Mutex) expiredBefore() int64 {
+	return m.clock().Now().UnixNano() - m.Expiry.Nanoseconds() - m.ExpirySkewTolerance.Nanoseconds()
+}
+
+// takeoverBefore returns the time in Unix nanoseconds before which a lock last written by someone else can be taken
+// over: it expired or, without an Expiry, it is older than StaleGracePeriod.
+func (m *Mutex) takeoverBefore() int64 {
+	if m.Expiry > 0 {
+		return m.expiredBefore()
+	}
+	return m.clock().Now().UnixNano() - m.StaleGracePeriod.Nanoseconds() - m.ExpirySkewTolerance.Nanoseconds()
+}
+
+// held reports whether the Mutex holds its lock, as far as this process knows: it was locked, it was not unlocked
+// and it did not expire since.
+func (m *Mutex) held() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.holdsLock()
+}
+
+// holdsLock implements held. The lock of the Mutex must be held.
+func (m *Mutex) holdsLock() bool {
+	if !m.locked {
+		return false
+	}
+	return m.Expiry <= 0 || m.lastWrite >= m.expiredBefore()
+}
+
+// claim marks the Mutex as being locked. It returns ErrAlreadyHeld if the Mutex holds the lock or is being locked
+// by another goroutine, so a Mutex shared by goroutines is never locked by two of them at once: their conditional
+// writes would both succeed, as they carry the same LockerID. Call unclaim once the attempt is over.
+func (m *Mutex) claim() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.acquiring || m.holdsLock() {
+		return ErrAlreadyHeld
+	}
+	m.acquiring = true
+	return nil
+}
+
+// unclaim ends an attempt to lock the Mutex started by claim.
+func (m *Mutex) unclaim() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acquiring = false
+}
+
+// isLocked reports whether the Mutex is locked. It reads the state under the lock of the Mutex, as the goroutines
+// renewing its lease may change it.
+func (m *Mutex) isLocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locked
+}
+
+// tryAcquire makes a single attempt at locking the Mutex. It returns false without an error if the lock is held by
+// someone else.
+func (m *Mutex) tryAcquire() (acquired bool, err error) {
+	if err = m.claim(); err != nil {
+		return
+	}
+	defer m.unclaim()
+	release, err := reserveHeldLock()
+	if err != nil {
+		return
+	}
+	defer release()
+	err = m.tryLock()
+	if err != nil {
+		if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// expireCredentials forces the credentials used by DDBSession to be refreshed on the next call.
+func (m *Mutex) expireCredentials() {
+	if client, ok := m.DDBSession.(*dynamodb.DynamoDB); ok {
+		client.Config.Credentials.Expire()
+	} else if m.AWSSession != nil && m.AWSSession.Config.Credentials != nil {
+		m.AWSSession.Config.Credentials.Expire()
+	}
+}
+
+// readItem reads a lock item through DDBReadSession with an eventually consistent read, or through DDBSession with
+// a strongly consistent read if DDBReadSession is not set. The returned map is nil if the item does not exist.
+func (m *Mutex) readItem(name string) (item map[string]*dynamodb.AttributeValue, err error) {
+	session := m.DDBReadSession
+	if session == nil {
+		session = m.DDBSession
+	}
+	result, err := session.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(m.DDBReadSession == nil),
+		Key:            m.key(name),
+		TableName:      &m.DDBTableName,
+	})
+	if err != nil {
+		return
+	}
+	item = result.Item
+	return
+}
+
+// getItem reads a lock item from the database with a strongly consistent read.
+// The returned map is nil if the item does not exist.
+func (m *Mutex) getItem(name string) (item map[string]*dynamodb.AttributeValue, err error) {
+	result, err := m.DDBSession.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            m.key(name),
+		TableName:      &m.DDBTableName,
+	})
+	if err != nil {
+		return
+	}
+	item = result.Item
+	return
+}
+
+// WithTimeout defines a custom timeout value when trying to lock a key. It returns a copy of the Mutex, so call it
+// before the Mutex is used, not while it is locked or renewing its lease.
+//
+// Set it to 0 for no timeout.
+//
+// Default timeout value: 5 seconds
+func (m Mutex) WithTimeout(timeout time.Duration) Mutex {
+	m.timeout = timeout
+	m.timeoutSet = true
+	return m
+}
+
+// Lock locks the Mutex and retrieves its value from the database.
+// If the lock is already in use, the calling goroutine blocks until the mutex is available
+// or the timeout period has been reached.
+//
+// It ignores previous locks if an expiry period has been set. If the previous lock has expired, it immediately
+// locks the lock. Locking a Mutex that is already held by the same instance panics with ErrAlreadyHeld.
+// Errors cause a panic, unless PanicMode says otherwise.
+//
+// The value is taken from the response of the conditional write that acquires the lock, which always reflects the
+// latest state of the item. A Lock following an Unlock therefore reads back the value written by that Unlock, even
+// from the same Mutex instance. This only holds if DDBSession reaches DynamoDB directly or through a write-through
+// layer like DAX; a proxy that answers writes from its own cache breaks the guarantee.
+func (m *Mutex) Lock() {
+	if err := m.lock(); err != nil {
+		m.fail(err)
+	}
+}
+
+// lock implements Lock, returning an error instead of panicking.
+func (m *Mutex) lock() (err error) {
+	return m.lockContext(context.Background())
+}
+
+// LockContext locks the Mutex like Lock, but gives up as soon as ctx is done and returns ctx.Err(). The timeout of
+// the Mutex still applies. Errors are returned instead of panicking.
+func (m *Mutex) LockContext(ctx context.Context) error {
+	return m.lockContext(ctx)
+}
+
+// lockContext implements Lock and LockContext.
+func (m *Mutex) lockContext(ctx context.Context) (err error) {
+	return m.lockWithin(ctx, -1)
+}
+
+// LockDeadline locks the Mutex like LockWithError, but waits until deadline instead of the timeout of the Mutex, for
+// callers with an absolute budget, like the deadline of a request. It returns ErrLockTimeout right away if deadline
+// has passed.
+func (m *Mutex) LockDeadline(deadline time.Time) error {
+	remaining := deadline.Sub(m.clock().Now())
+	if remaining <= 0 {
+		m.metrics().IncTimeout()
+		return ErrLockTimeout
+	}
+	return m.lockWithin(context.Background(), remaining)
+}
+
+// lockWithin implements lockContext and LockDeadline. It waits for at most timeout, or the timeout of the Mutex if
+// timeout is negative.
+func (m *Mutex) lockWithin(ctx context.Context, timeout time.Duration) (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	if timeout < 0 {
+		timeout = m.timeout
+	}
+	if err = m.claim(); err != nil {
+		return
+	}
+	defer m.unclaim()
+	release, err := reserveHeldLock()
+	if err != nil {
+		return
+	}
+	defer release()
+	if m.InitialJitter > 0 && !m.DisableJitter {
+		if err = sleep(ctx, time.Duration(randomInt63n(int64(m.InitialJitter)))); err != nil {
+			return
+		}
+	}
+	if m.Fair {
+		if err = m.takeTicket(); err != nil {
+			return
+		}
+		defer func() { m.ticket = 0 }()
+	}
+	started := m.clock().Now().UnixNano()
+	waiting := false
+	for attempt := 0; ; attempt++ {
+		err = m.tryLock()
+		if err != nil {
+			if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+				if !waiting {
+					waiting = true
+					if m.TrackWaiters {
+						m.addWaiters(1)
+						defer m.addWaiters(-1)
+					}
+					err = m.inspectConflict()
+					if err != nil {
+						return
+					}
+					m.recordFailedAttempt()
+				}
+				if timeout > 0 && started < m.clock().Now().UnixNano()-timeout.Nanoseconds() {
+					m.metrics().IncTimeout()
+					if m.DiagnoseConflicts {
+						return fmt.Errorf("%w: lock is %s", ErrLockTimeout, m.holder())
+					}
+					return ErrLockTimeout
+				}
+				if m.MaxAttempts > 0 && attempt+1 >= m.MaxAttempts {
+					return ErrMaxAttempts
+				}
+				remaining := time.Duration(math.MaxInt64)
+				if timeout > 0 {
+					remaining = time.Duration(started + timeout.Nanoseconds() - m.clock().Now().UnixNano())
+				}
+				delay := capBackoff(m.retryDelay(attempt), m.MaxBackoff, remaining)
+				m.logf("lock %s is held, retrying in %v", m.Name, delay)
+				if err = sleep(ctx, delay); err != nil {
+					return
+				}
+				continue
+			}
+			return
+		}
+		wait := time.Duration(m.clock().Now().UnixNano() - started)
+		m.metrics().ObserveWait(wait)
+		m.logf("locked %s after %v, fencing token %d", m.Name, wait, m.fence)
+		return
+	}
+}
+
+// sleep waits for the given duration, or until ctx is done. It returns ctx.Err() if ctx is done first.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryDelay returns the delay before the next attempt to lock, after the given number of failed attempts: a random
+// delay up to the bound from retryBound, or exactly that bound if DisableJitter is set.
+func (m *Mutex) retryDelay(attempt int) time.Duration {
+	bound := m.retryBound(attempt)
+	if m.DisableJitter || bound <= 0 {
+		return bound
+	}
+	return time.Duration(randomInt63n(int64(bound)))
+}
+
+// retryBound returns the upper bound of the delay before the next attempt to lock. Without RetryBackoff it is always
+// maxRetryDelay. With RetryBackoff it doubles with every attempt, up to MaxBackoff or maxRetryBackoff.
+func (m *Mutex) retryBound(attempt int) time.Duration {
+	if m.RetryBackoff <= 0 {
+		return maxRetryDelay
+	}
+	limit := maxRetryBackoff
+	if m.MaxBackoff > 0 {
+		limit = m.MaxBackoff
+	}
+	bound := m.RetryBackoff
+	for i := 0; i < attempt && bound < limit; i++ {
+		bound *= 2
+	}
+	if bound > limit {
+		bound = limit
+	}
+	return bound
+}
+
+// capBackoff limits the delay between two attempts to lock to maxBackoff, if it is set, and to the remaining time
+// until the timeout, so the last attempt is not made late.
+func capBackoff(delay, maxBackoff, remaining time.Duration) time.Duration {
+	if maxBackoff > 0 && delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if delay > remaining {
+		delay = remaining
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// addWaiters adds delta to the number of waiters of the lock item. Failures are only logged,
+// as the waiter count is informational.
+func (m *Mutex) addWaiters(delta int) {
+	_, err := m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("attribute_exists(#name)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name":    aws.String(m.KeyAttributeName),
+			"#waiters": aws.String("Waiters"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":delta": {
+				N: aws.String(strconv.Itoa(delta)),
+			},
+		},
+		Key:              m.key(m.Name),
+		UpdateExpression: aws.String("ADD #waiters :delta"),
+		TableName:        &m.DDBTableName,
+	})
+	if err != nil {
+		m.logf("could not update waiter count of %s: %v", m.Name, err)
+	}
+}
+
+// inspectConflict reads the lock item after a failed attempt to lock it. It returns ErrLockRetired if the lock was
+// retired, and logs the holder of the lock if DiagnoseConflicts is set.
+func (m *Mutex) inspectConflict() (err error) {
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	if isRetired(item) {
+		return ErrLockRetired
+	}
+	if m.DiagnoseConflicts {
+		m.logf("lock %s is %s", m.Name, m.describeHolder(item))
+	}
+	return
+}
+
+// isRetired reports whether a lock item was retired.
+func isRetired(item map[string]*dynamodb.AttributeValue) bool {
+	_, ok := item["Retired"]
+	return ok
+}
+
+// Retire marks the lock item with the given name as retired, creating it if necessary. A retired lock cannot be
+// locked anymore: Lock and TryLock fail with ErrLockRetired. Holders of the lock are not affected until they
+// unlock it. Use it to phase out a lock, for example when migrating to a new lock name.
+func (m *Mutex) Retire(name string) (err error) {
+	return m.setRetired(name, true)
+}
+
+// Unretire reverses Retire, so the lock item with the given name can be locked again.
+func (m *Mutex) Unretire(name string) (err error) {
+	return m.setRetired(name, false)
+}
+
+// setRetired sets or removes the retired flag of a lock item.
+func (m *Mutex) setRetired(name string, retired bool) (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#retired": aws.String("Retired"),
+		},
+		Key:              m.key(name),
+		UpdateExpression: aws.String("REMOVE #retired"),
+		TableName:        &m.DDBTableName,
+	}
+	if retired {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":retired": {
+				BOOL: aws.Bool(true),
+			},
+		}
+		input.UpdateExpression = aws.String("SET #retired=:retired")
+	}
+	_, err = m.DDBSession.UpdateItem(input)
+	return
+}
+
+// SetNotBefore stores a time on the lock item with the given name before which it cannot be locked, creating the item
+// if necessary. Until then Lock keeps waiting and TryLock returns false, so processes racing for the lock all start
+// at the time stored in the item. A zero time removes the restriction.
+func (m *Mutex) SetNotBefore(name string, notBefore time.Time) (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#notbefore": aws.String("NotBefore"),
+		},
+		Key:              m.key(name),
+		UpdateExpression: aws.String("REMOVE #notbefore"),
+		TableName:        &m.DDBTableName,
+	}
+	if !notBefore.IsZero() {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":notbefore": {
+				N: aws.String(strconv.FormatInt(notBefore.UnixNano(), 10)),
+			},
+		}
+		input.UpdateExpression = aws.String("SET #notbefore=:notbefore")
+	}
+	_, err = m.DDBSession.UpdateItem(input)
+	return
+}
+
+// Owner returns the LockerID of the current holder of the lock, and the time the lock item was last written, with
+// a strongly consistent read. It does not try to lock. The id is 0 if the lock is free, and the time is zero if the
+// lock item does not exist.
+//
+// An expired lock is still reported with the id of its last holder, compare lastWrite with Expiry to tell.
+func (m *Mutex) Owner() (id int64, lastWrite time.Time, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	item, err := m.getItem(m.Name)
+	if err != nil || item == nil {
+		return
+	}
+	if attribute, ok := item[m.LockerIDAttributeName]; ok && attribute.N != nil {
+		id, err = strconv.ParseInt(*attribute.N, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	if attribute, ok := item[m.LastWriteAttributeName]; ok {
+		lastWrite, err = ParseLastWrite(attribute)
+	}
+	return
+}
+
+// Peek reports whether the lock is held, by whom and when the hold expires, with a strongly consistent read. It does
+// not try to lock and does not change the lock item, so it is safe for monitoring and admin tools.
+//
+// The lock is not held if its item does not exist or has no holder. With Expiry, expiresAt is the last write of the
+// lock item plus Expiry, and an expired lock is reported as not held, although owner is still its last holder.
+// Without Expiry, expiresAt is zero.
+func (m *Mutex) Peek() (held bool, owner int64, expiresAt time.Time, err error) {
+	owner, lastWrite, err := m.Owner()
+	if err != nil || owner == 0 {
+		return
+	}
+	if m.Expiry <= 0 {
+		return true, owner, expiresAt, nil
+	}
+	expiresAt = lastWrite.Add(m.Expiry)
+	held = m.clock().Now().Before(expiresAt)
+	return
+}
+
+// holder describes who holds the lock of the Mutex, for diagnostics.
+func (m *Mutex) holder() string {
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return fmt.Sprintf("held by an unknown holder (%v)", err)
+	}
+	return m.describeHolder(item)
+}
+
+// describeHolder describes who holds the lock of a lock item.
+func (m *Mutex) describeHolder(item map[string]*dynamodb.AttributeValue) string {
+	id, lastWrite := "none", "never"
+	if attribute, ok := item[m.LockerIDAttributeName]; ok && attribute.N != nil {
+		id = *attribute.N
+	}
+	if written, err := ParseLastWrite(item[m.LastWriteAttributeName]); err == nil {
+		lastWrite = written.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("held by %s since %s", id, lastWrite)
+}
+
+// ReapExpired unlocks all locks in the table of the Mutex that expired, according to the Expiry of the Mutex,
+// and returns the number of locks it unlocked. Values are not changed.
+//
+// Expired locks are taken over by the next process that locks them anyway. ReapExpired is for housekeeping,
+// for example to keep the number of held locks in the table accurate.
+func (m *Mutex) ReapExpired() (count int, err error) {
+	if m.Expiry <= 0 {
+		return 0, errors.New("locks cannot expire without an Expiry")
+	}
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+
+	condition := "#id <> :zero AND ( attribute_not_exists(#lastwrite) OR #lastwrite < :nowminusexpiry )"
+	expressionAttributeNames := map[string]*string{
+		"#name":      aws.String(m.KeyAttributeName),
+		"#lastwrite": aws.String(m.LastWriteAttributeName),
+		"#id":        aws.String(m.LockerIDAttributeName),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":zero": {
+			N: aws.String("0"),
+		},
+		":nowminusexpiry": {
+			N: aws.String(strconv.FormatInt(m.expiredBefore(), 10)),
+		},
+	}
+
+	var expired []map[string]*dynamodb.AttributeValue
+	err = m.DDBSession.ScanPages(&dynamodb.ScanInput{
+		ConsistentRead:            aws.Bool(true),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		FilterExpression:          aws.String(condition),
+		ProjectionExpression:      aws.String("#name, #id"),
+		TableName:                 &m.DDBTableName,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		expired = append(expired, page.Items...)
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	for _, item := range expired {
+		// The lock may have been taken over or refreshed since the scan
+		expressionAttributeValues[":id"] = item[m.LockerIDAttributeName]
+		_, err = m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+			ConditionExpression:       aws.String("#id = :id AND ( attribute_not_exists(#lastwrite) OR #lastwrite < :nowminusexpiry )"),
+			ExpressionAttributeNames:  map[string]*string{"#id": aws.String(m.LockerIDAttributeName), "#lastwrite": aws.String(m.LastWriteAttributeName)},
+			ExpressionAttributeValues: expressionAttributeValues,
+			Key: map[string]*dynamodb.AttributeValue{
+				m.KeyAttributeName: item[m.KeyAttributeName],
+			},
+			UpdateExpression: aws.String("SET #id=:zero"),
+			TableName:        &m.DDBTableName,
+		})
+		if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		m.logf("reaped expired lock %s", *item[m.KeyAttributeName].S)
+		count++
+	}
+	return
+}
+
+// WaiterCount returns the number of processes currently waiting for the lock item with the given name.
+// Only Mutexes with TrackWaiters set are counted.
+func (m *Mutex) WaiterCount(name string) (count int, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	item, err := m.readItem(name)
+	if err != nil {
+		return
+	}
+	if waiters, ok := item["Waiters"]; ok {
+		return strconv.Atoi(*waiters.N)
+	}
+	return
+}
+
+// Unlock writes the value into the database and unlocks the Mutex.
+// It is a run-time error if the Mutex is not locked on entry to Unlock.
+//
+// A locked Mutex is associated with a particular Mutex variable.
+// If a mutex expires, it is automatically considered unlocked.
+// Errors cause a panic, unless PanicMode says otherwise.
+func (m *Mutex) Unlock() {
+	if err := m.unlock(); err != nil {
+		m.fail(err)
+	}
+}
+
+// unlock implements Unlock, returning an error instead of panicking.
+func (m *Mutex) unlock() (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = m.tryUnlock()
+	if err == nil {
+		m.metrics().IncUnlock()
+		m.logf("unlocked %s", m.Name)
+	}
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		if m.locked {
+			// The lock expired and somebody else took it over in the meantime
+			m.released()
+			return ErrFencedOut
+		}
+		return ErrNotOwner
+	}
+	if m.UnlockMissingTable && isErrCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		m.logf("table %s is gone, considering %s unlocked", m.DDBTableName, m.Name)
+		m.released()
+		return nil
+	}
+	return
+}
+
+// ForceUnlock unlocks the lock of the Mutex whoever holds it. It is an administrative escape hatch for locks left
+// behind by a crashed process without Expiry.
+//
+// ForceUnlock violates mutual exclusion if the holder is still alive: it keeps working as if it held the lock, and
+// its Unlock fails with ErrNotOwner or ErrFencedOut. Only use it once the holder is known to be gone. The value of
+// the Mutex is only written into the database if the Mutex holds the lock or its value was set since it last
+// released the lock, for example to repair it; otherwise the stored value is left untouched.
+func (m *Mutex) ForceUnlock() (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = m.writeUnlock(true, nil)
+	if err == nil {
+		m.logf("force unlocked %s", m.Name)
+	}
+	return
+}
+
+// TryLock makes a single attempt to lock the Mutex, without waiting. It returns false and no error if the lock is
+// held by someone else, and an error if the attempt failed for another reason, like ErrLockRetired or an error
+// returned by DynamoDB. It never sleeps or panics, so callers can poll on their own terms.
+func (m *Mutex) TryLock() (acquired bool, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	acquired, err = m.tryAcquire()
+	if err != nil || acquired {
+		return
+	}
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	if isRetired(item) {
+		return false, ErrLockRetired
+	}
+	m.recordFailedAttempt()
+	return
+}
 
-	initialized bool
+// LockWithError locks the Mutex like Lock, but returns an error instead of panicking. If the timeout elapses, the
+// error is ErrLockTimeout.
+func (m *Mutex) LockWithError() error {
+	return m.lock()
+}
 
-	timeout    time.Duration
-	timeoutSet bool
+// UnlockWithError unlocks the Mutex like Unlock, but returns an error instead of panicking. If the Mutex does not
+// hold the lock, the error is ErrNotOwner, or ErrFencedOut if the lock expired and was taken over.
+func (m *Mutex) UnlockWithError() error {
+	return m.unlock()
+}
 
-	value string
-	id    int64
+// TryUnlock writes the value into the database and unlocks the Mutex, like Unlock.
+// It returns an error instead of panicking if the Mutex could not be unlocked.
+func (m *Mutex) TryUnlock() error {
+	return m.unlock()
 }
 
-func (m *Mutex) initialization() (err error) {
+// WithLock locks the Mutex, runs fn and unlocks the Mutex, writing the value fn set. The Mutex is unlocked even if
+// fn returns an error or panics, in which case the panic continues after the unlock. It returns the error of fn,
+// or else the error of locking or unlocking the Mutex.
+func (m *Mutex) WithLock(fn func() error) error {
+	return m.WithLockContext(context.Background(), fn)
+}
 
-	if m.initialized {
+// WithLockContext is WithLock, giving up on locking as soon as ctx is done, like LockContext.
+func (m *Mutex) WithLockContext(ctx context.Context, fn func() error) (err error) {
+	err = m.lockContext(ctx)
+	if err != nil {
 		return
 	}
+	defer func() {
+		unlockErr := m.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return fn()
+}
 
-	// Defaults
-	if m.AWSRegion == "" {
-		m.AWSRegion = "us-east-1"
-	}
-	if m.DDBTableName == "" {
-		m.DDBTableName = "Locks"
-	}
-	if m.Name == "" {
-		m.Name = "Lock"
+// Once runs fn exactly once across all processes sharing the lock item, like sync.Once does within a process.
+//
+// It locks the Mutex, runs fn unless a previous call already completed it, records the completion in the database
+// and unlocks the Mutex. It returns false if fn was already run, possibly by another process.
+//
+// If fn returns an error or panics, it is not considered done: the next call to Once runs it again. The error is
+// returned together with true. The lock item should be dedicated to this purpose, as Once stores its completion
+// flag next to the value of the Mutex.
+func (m *Mutex) Once(fn func() error) (ran bool, err error) {
+	err = m.lock()
+	if err != nil {
+		return
 	}
+	defer func() {
+		unlockErr := m.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
 
-	if m.GetValueString() == "" {
-		m.SetValueInt64(0)
+	if done, ok := m.item["Done"]; ok && done.BOOL != nil && *done.BOOL {
+		return
 	}
 
-	// Create AWS session, if it does not exist
-	if m.AWSSession == nil {
-		cfg := aws.Config{
-			Region: aws.String(m.AWSRegion),
-		}
-		// Use IAM or environment variables credential
-		if !m.IgnoreEnvVars &&
-			((os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "") ||
-				(os.Getenv("AWS_ACCESS_KEY") != "" && os.Getenv("AWS_SECRET_KEY") != "")) {
-			cfg.Credentials = credentials.NewEnvCredentials()
-		}
-		m.AWSSession = session.Must(session.NewSessionWithOptions(session.Options{Config: cfg}))
-	}
-	// Create DynamoDB session, if it does not exist
-	if m.DDBSession == nil {
-		m.DDBSession = dynamodb.New(m.AWSSession)
+	ran = true
+	err = fn()
+	if err != nil {
+		return
 	}
 
-	// Check table existence and create if not exists
-	listTablesOutput, err := m.DDBSession.ListTables(&dynamodb.ListTablesInput{})
-	found := false
-	for item := range listTablesOutput.TableNames {
-		if *listTablesOutput.TableNames[item] == m.DDBTableName {
-			found = true
-			break
-		}
-	}
-	if !found {
-		_, err := m.DDBSession.CreateTable(&dynamodb.CreateTableInput{
-			AttributeDefinitions: []*dynamodb.AttributeDefinition{
-				{
-					AttributeName: aws.String("Name"),
-					AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
-				},
+	_, err = m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#id = :id AND #fence = :fence"),
+		ExpressionAttributeNames: map[string]*string{
+			"#done":  aws.String("Done"),
+			"#id":    aws.String(m.LockerIDAttributeName),
+			"#fence": aws.String("Fence"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":done": {
+				BOOL: aws.Bool(true),
 			},
-			KeySchema: []*dynamodb.KeySchemaElement{
-				{
-					AttributeName: aws.String("Name"),
-					KeyType:       aws.String(dynamodb.KeyTypeHash),
-				},
+			":id": {
+				N: aws.String(strconv.FormatInt(m.id, 10)),
 			},
-			// Todo: Make the capacity units configurable
-			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-				ReadCapacityUnits:  aws.Int64(5),
-				WriteCapacityUnits: aws.Int64(5),
+			":fence": {
+				N: aws.String(strconv.FormatUint(m.fence, 10)),
 			},
-			TableName: aws.String(m.DDBTableName),
-		})
-		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				if aerr.Code() != dynamodb.ErrCodeResourceInUseException {
-					panic(fmt.Sprintf("sync table not created: %v", err))
-				}
-			} else {
-				panic(fmt.Sprintf("sync table not created: %v", err))
-			}
-		}
+		},
+		Key:              m.key(m.Name),
+		UpdateExpression: aws.String("SET #done=:done"),
+		TableName:        &m.DDBTableName,
+	})
+	return
+}
+
+// Close ends the use of the Mutex. It unlocks the Mutex and the names locked with LockKey, stops renewing its lease
+// and closes the idle HTTP connections of the AWS session if the session was created by the Mutex. Sessions passed
+// in through AWSSession are not touched.
+//
+// A closed Mutex can be reconfigured and used again: it is initialized anew on the next use. The sessions are kept,
+// set AWSSession and DDBSession to nil to have new ones created, for example for another region.
+func (m *Mutex) Close() (err error) {
+	if !m.initialized {
+		return
 	}
-	for {
-		tableDescription, err := m.DDBSession.DescribeTable(&dynamodb.DescribeTableInput{
-			TableName: aws.String(m.DDBTableName),
-		})
-		if *tableDescription.Table.TableStatus == dynamodb.TableStatusActive {
-			break
-		}
-		if *tableDescription.Table.TableStatus == dynamodb.TableStatusCreating {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		if err == nil {
-			err = errors.New(fmt.Sprintf("error activating table. Table status: %v", *tableDescription.Table.TableStatus))
+	if m.isLocked() {
+		err = m.unlock()
+	}
+	if m.keys != nil {
+		if keysErr := m.keys.UnlockAll(); keysErr != nil && err == nil {
+			err = keysErr
 		}
-		panic(fmt.Sprintf("could not access table: %v", err.Error()))
 	}
+	m.mu.Lock()
+	m.stopWatchdog()
+	m.stopRenewal()
+	m.mu.Unlock()
+	if m.ownSession && m.AWSSession.Config.HTTPClient != nil {
+		m.AWSSession.Config.HTTPClient.CloseIdleConnections()
+	}
+	m.initialized = false
+	return
+}
 
-	rand.Seed(time.Now().UnixNano())
-	for m.id == 0 {
-		m.id = rand.Int63()
+// GetOrAcquire tries to lock the Mutex without blocking. If the lock was acquired, it returns the value of the Mutex
+// and true. The caller is then responsible for unlocking the Mutex.
+//
+// If the lock is held by someone else, it returns the value currently stored in the database (using a consistent
+// read) and false. The Mutex is not locked in this case and its local value is not changed.
+//
+// This covers the "become the leader or at least learn the current state" pattern in one call.
+func (m *Mutex) GetOrAcquire() (value string, acquired bool, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	acquired, err = m.tryAcquire()
+	if err != nil {
+		return
+	}
+	if acquired {
+		value = m.GetValueString()
+		return
 	}
 
-	if !m.timeoutSet {
-		m.timeout = 5 * time.Second
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	value, _, err = decodeValue(item, m.ValueAttributeName)
+	if err == nil && isRetired(item) {
+		err = ErrLockRetired
 	}
-	m.initialized = true
 	return
-
 }
 
-func (m *Mutex) tryLock() (err error) {
+// InitializeValue creates the lock item in the database with the given value, unlocked, if it does not exist yet.
+// It returns true if the item was created and false if it already existed, in which case nothing is changed.
+//
+// It does not lock the Mutex. Use it to seed a value once, for example at deployment time.
+func (m *Mutex) InitializeValue(value string) (created bool, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
 
-	// Create lock in database
-	condition := "attribute_not_exists(#name) OR attribute_not_exists(#id) OR #id = :zero OR #id = :id"
-	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-		":lastwrite": {
-			N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
+	value, encoding, err := m.encodeValue(value)
+	if err != nil {
+		return
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		m.KeyAttributeName: {
+			S: aws.String(m.Name),
 		},
-		":id": {
-			N: aws.String(strconv.FormatInt(m.id, 10)),
+		m.ValueAttributeName: {
+			S: aws.String(value),
 		},
-		":zero": {
+		m.LastWriteAttributeName: {
+			N: aws.String(strconv.FormatInt(m.clock().Now().UnixNano(), 10)),
+		},
+		m.LockerIDAttributeName: {
 			N: aws.String("0"),
 		},
 	}
-
-	if m.Expiry > 0 {
-		condition = condition + " OR ( #id <> :id AND #lastwrite < :nowminusexpiry )"
-		expressionAttributeValues[":nowminusexpiry"] = &dynamodb.AttributeValue{
-			N: aws.String(strconv.FormatInt(time.Now().UnixNano()-m.Expiry.Nanoseconds(), 10)),
+	if encoding != "" {
+		item["Encoding"] = &dynamodb.AttributeValue{
+			S: aws.String(encoding),
+		}
+	}
+	if m.VerifyIntegrity {
+		item["Checksum"] = &dynamodb.AttributeValue{
+			S: aws.String(checksum(value)),
 		}
 	}
 
-	result, err := m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
-		ConditionExpression: &condition,
+	_, err = m.DDBSession.PutItem(&dynamodb.PutItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(#name)"),
 		ExpressionAttributeNames: map[string]*string{
-			"#name":      aws.String("Name"),
-			"#lastwrite": aws.String("LastWrite"),
-			"#id":        aws.String("LockerID"),
-		},
-		ExpressionAttributeValues: expressionAttributeValues,
-		Key: map[string]*dynamodb.AttributeValue{
-			"Name": {
-				S: aws.String(m.Name),
-			},
+			"#name": aws.String(m.KeyAttributeName),
 		},
-		ReturnValues:     aws.String(dynamodb.ReturnValueAllNew),
-		UpdateExpression: aws.String("SET #lastwrite=:lastwrite, #id=:id"),
-		TableName:        &m.DDBTableName,
+		Item:      item,
+		TableName: &m.DDBTableName,
 	})
-
 	if err != nil {
+		if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			err = nil
+		}
 		return
 	}
+	return true, nil
+}
 
-	if value, ok := result.Attributes["Value"]; ok {
-		m.SetValueString(*value.S)
+// RepairRow normalizes the lock item with the given name in the table of the Mutex. A LockerID or LastWrite
+// attribute that is missing or is not a number (for example after a manual edit of the table) is reset to zero.
+// This unlocks a lock with a malformed LockerID, and makes a lock with a malformed LastWrite immediately
+// expired for Mutexes that have an Expiry set.
+//
+// Well-formed and missing items are left untouched. RepairRow is an administrative tool and does not check
+// who holds the lock.
+func (m *Mutex) RepairRow(name string) (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
 	}
 
-	return
-}
-
-func (m *Mutex) tryUnlock() (err error) {
+	item, err := m.getItem(name)
+	if err != nil || item == nil {
+		return
+	}
 
-	condition := "attribute_not_exists(#name) OR #id = :id"
+	var updates, conditions []string
+	expressionAttributeNames := map[string]*string{}
+	for placeholder, attribute := range map[string]string{"#id": m.LockerIDAttributeName, "#lastwrite": m.LastWriteAttributeName} {
+		if value, ok := item[attribute]; ok && value.N != nil {
+			continue
+		}
+		expressionAttributeNames[placeholder] = aws.String(attribute)
+		updates = append(updates, placeholder+"=:zero")
+		conditions = append(conditions, "( attribute_not_exists("+placeholder+") OR NOT attribute_type("+placeholder+", :number) )")
+	}
+	if len(updates) == 0 {
+		return
+	}
+	sort.Strings(updates)
+	sort.Strings(conditions)
+	m.logf("repairing lock item %s: %s", name, strings.Join(updates, ", "))
 
 	_, err = m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
-		ConditionExpression: &condition,
-		ExpressionAttributeNames: map[string]*string{
-			"#name":      aws.String("Name"),
-			"#value":     aws.String("Value"),
-			"#lastwrite": aws.String("LastWrite"),
-			"#id":        aws.String("LockerID"),
-		},
+		ConditionExpression:      aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames: expressionAttributeNames,
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":lastwrite": {
-				N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
-			},
-			":id": {
-				N: aws.String(strconv.FormatInt(m.id, 10)),
-			},
 			":zero": {
 				N: aws.String("0"),
 			},
-			":value": {
-				S: aws.String(m.GetValueString()),
-			},
-		},
-		Key: map[string]*dynamodb.AttributeValue{
-			"Name": {
-				S: aws.String(m.Name),
+			":number": {
+				S: aws.String(dynamodb.ScalarAttributeTypeN),
 			},
 		},
-		UpdateExpression: aws.String("SET #lastwrite=:lastwrite, #id=:zero, #value=:value"),
+		Key:              m.key(name),
+		UpdateExpression: aws.String("SET " + strings.Join(updates, ", ")),
 		TableName:        &m.DDBTableName,
 	})
-
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		// The item was fixed concurrently
+		err = nil
+	}
 	return
 }
 
-// WithTimeout defines a custom timeout value when trying to lock a key.
-//
-// Set it to 0 for no timeout.
+// AtomicIncrement adds delta to the counter of the lock item with the given name and returns the new count.
+// The item and the counter are created if they do not exist.
 //
-// Default timeout value: 5 seconds
-func (m Mutex) WithTimeout(timeout time.Duration) Mutex {
-	m.timeout = timeout
-	m.timeoutSet = true
-	return m
-}
+// The counter is a number attribute stored next to the value of the Mutex. It is updated in a single atomic write
+// that does not lock the Mutex: use it for counters that need atomicity, but not mutual exclusion.
+func (m *Mutex) AtomicIncrement(name string, delta int64) (count int64, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
 
-// Lock locks the Mutex and retrieves its value from the database.
-// If the lock is already in use, the calling goroutine blocks until the mutex is available
-// or the timeout period has been reached.
-//
-// It ignores previous locks if an expiry period has been set. If the previous lock has expired, it immediately
-// locks the lock.
-func (m *Mutex) Lock() {
-	m.initialization()
-	started := time.Now().UnixNano()
-	for {
-		err := m.tryLock()
-		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-					if started < time.Now().UnixNano()-m.timeout.Nanoseconds() {
-						panic(errors.New("could not lock mutex"))
-					} else {
-						time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
-						continue
-					}
-				}
-			}
-			panic(err)
-		} else {
-			break
-		}
+	result, err := m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#counter": aws.String("Counter"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":delta": {
+				N: aws.String(strconv.FormatInt(delta, 10)),
+			},
+		},
+		Key:              m.key(name),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedNew),
+		UpdateExpression: aws.String("ADD #counter :delta"),
+		TableName:        &m.DDBTableName,
+	})
+	if err != nil {
+		return
 	}
+
+	return strconv.ParseInt(*result.Attributes["Counter"].N, 10, 64)
 }
 
-// Unlock writes the value into the database and unlocks the Mutex.
-// It is a run-time error if the Mutex is not locked on entry to Unlock.
+// LockIncrementUnlock locks the Mutex, adds delta to its int64 value, unlocks it and returns the new value.
+// An empty value counts as 0. The Mutex is unlocked without changes if its value is not an integer.
 //
-// A locked Mutex is associated with a particular Mutex variable.
-// If a mutex expires, it is automatically considered unlocked.
-func (m *Mutex) Unlock() {
-	m.initialization()
-	err := m.tryUnlock()
+// It replaces the lock, read, write and unlock sequence of counters shared through the value of the Mutex.
+// Counters that need atomicity, but not mutual exclusion, are cheaper with AtomicIncrement.
+func (m *Mutex) LockIncrementUnlock(delta int64) (value int64, err error) {
+	err = m.lock()
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-				panic(errors.New("could not unlock mutex"))
+		return
+	}
+	if m.value != "" {
+		value, err = strconv.ParseInt(m.value, 10, 64)
+		if err != nil {
+			if unlockErr := m.unlock(); unlockErr != nil {
+				return 0, unlockErr
 			}
+			return 0, err
 		}
-		panic(err)
 	}
+	value += delta
+	m.SetValueInt64(value)
+	err = m.unlock()
+	return
 }
 
 // GetValueInt64 gets the value from the Mutex and returns it as an int64.
@@ -337,12 +2087,30 @@ func (m *Mutex) GetValueUint64() uint64 {
 	return result
 }
 
+// GetValueFloat64 gets the value from the Mutex and returns it as a float64.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueFloat64() float64 {
+
+	if m.value == "" {
+		return 0
+	}
+
+	result, err := strconv.ParseFloat(m.value, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return result
+}
+
 // SetValueInt64 sets the int64 value in the Mutex. It does not check if the Mutex was locked beforehand. It does not write
 // the value into the database. The value is written to the database during Unlock.
 //
 // See example(s) at GetValueInt64
 func (m *Mutex) SetValueInt64(value int64) {
 	m.value = strconv.FormatInt(value, 10)
+	m.valueSet = true
 }
 
 // SetValueUint64 sets the uint64 value in the Mutex. It does not check if the Mutex was locked beforehand. It does not write
@@ -351,6 +2119,16 @@ func (m *Mutex) SetValueInt64(value int64) {
 // See example(s) at GetValueUint64
 func (m *Mutex) SetValueUint64(value uint64) {
 	m.value = strconv.FormatUint(value, 10)
+	m.valueSet = true
+}
+
+// SetValueFloat64 sets the float64 value in the Mutex. It does not check if the Mutex was locked beforehand. It does not write
+// the value into the database. The value is written to the database during Unlock.
+//
+// The value is stored with the shortest representation that reads back exactly.
+func (m *Mutex) SetValueFloat64(value float64) {
+	m.value = strconv.FormatFloat(value, 'g', -1, 64)
+	m.valueSet = true
 }
 
 // GetValueString gets the value from the Mutex and returns it as a string.
@@ -360,12 +2138,40 @@ func (m *Mutex) GetValueString() string {
 	return m.value
 }
 
+// GetValueConsistent reads the value of the lock from the database with a strongly consistent read, without locking
+// the Mutex. It returns the latest value written by an Unlock, or DefaultValue if the lock item has none.
+//
+// It bypasses locking and does not change the value held by the Mutex: use it for read-only observers, like
+// dashboards that poll the shared value, never to read a value that is then written back.
+func (m *Mutex) GetValueConsistent() (value string, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	item, err := m.getItem(m.Name)
+	if err != nil {
+		return
+	}
+	if m.VerifyIntegrity {
+		err = verifyChecksum(item, m.ValueAttributeName)
+		if err != nil {
+			return
+		}
+	}
+	value, ok, err := decodeValue(item, m.ValueAttributeName)
+	if err != nil || !ok {
+		return m.DefaultValue, err
+	}
+	return
+}
+
 // SetValueString sets the string value in the Mutex. It does not check if the Mutex was locked beforehand. It does not write
 // the value into the database. The value is written to the database during Unlock.
 //
 // See example(s) at GetValueString
 func (m *Mutex) SetValueString(value string) {
 	m.value = value
+	m.valueSet = true
 }
 
 // LockAndGetValueString is shorthand for locking the Mutex and retrieving its string value.
@@ -375,9 +2181,101 @@ func (m *Mutex) LockAndGetValueString() string {
 }
 
 // SetValueStringAndUnlock is shorthand for setting string the value in the Mutex and unlocking it.
-func (m *Mutex) SetValueStringAndUnlock(value string) {
+//
+// It returns the error of the unlock instead of panicking. Unless the error is ErrFencedOut, the Mutex is still
+// locked and keeps the new value, so the unlock can be retried with TryUnlock without redoing the work.
+func (m *Mutex) SetValueStringAndUnlock(value string) error {
 	m.SetValueString(value)
-	m.Unlock()
+	return m.unlock()
+}
+
+// FencingToken returns the fencing token of the last acquisition of the lock.
+//
+// The token grows every time the lock is acquired, by any process. Pass it along with writes to other systems,
+// so they can reject writes carrying a lower token than one they have already seen: those come from a holder
+// whose lock expired and was taken over. Unlock uses the token the same way to protect the value of the Mutex.
+// The token starts over if the lock item is deleted, for example by TTL, see EnableTTL.
+func (m *Mutex) FencingToken() uint64 {
+	return m.fence
+}
+
+// Refresh renews the lease of the lock held by the Mutex, so it does not expire while work is still in progress.
+// The expiry is counted from the time of the refresh.
+//
+// It returns ErrNotOwner if the Mutex is not locked, and ErrFencedOut if the lock expired and was taken over.
+func (m *Mutex) Refresh() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locked {
+		return ErrNotOwner
+	}
+	lastWrite := m.clock().Now().UnixNano()
+	expressionAttributeNames := map[string]*string{
+		"#lastwrite": aws.String(m.LastWriteAttributeName),
+		"#id":        aws.String(m.LockerIDAttributeName),
+		"#fence":     aws.String("Fence"),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":lastwrite": {
+			N: aws.String(strconv.FormatInt(lastWrite, 10)),
+		},
+		":id": {
+			N: aws.String(strconv.FormatInt(m.id, 10)),
+		},
+		":fence": {
+			N: aws.String(strconv.FormatUint(m.fence, 10)),
+		},
+	}
+	set := []string{"#lastwrite=:lastwrite"}
+	if m.EnableTTL {
+		set = append(set, "#expiresat=:expiresat")
+		expressionAttributeNames["#expiresat"] = aws.String(ttlAttribute)
+		expressionAttributeValues[":expiresat"] = m.ttlExpiry(lastWrite)
+	}
+	_, err = m.updateItem("refresh", &dynamodb.UpdateItemInput{
+		ConditionExpression:       aws.String("#id = :id AND #fence = :fence"),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		Key:                       m.key(m.Name),
+		UpdateExpression:          aws.String(updateExpression(set, nil)),
+		TableName:                 &m.DDBTableName,
+	})
+	if isErrCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		m.released()
+		return ErrFencedOut
+	}
+	if err != nil {
+		return
+	}
+	m.lastWrite = lastWrite
+	m.startWatchdog()
+	return
+}
+
+// ExpiresAt returns the time at which the current hold of the lock expires, computed from the time it was acquired
+// and Expiry. It returns false if the Mutex is not locked or does not expire.
+//
+// Other processes take the lock over only after ExpirySkewTolerance has also passed, but work should be finished
+// or the lease renewed before the returned time.
+func (m *Mutex) ExpiresAt() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locked || m.Expiry <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, m.lastWrite).Add(m.Expiry), true
+}
+
+// GetLastWrite returns the time the lock item was last written before the Mutex acquired it, by the unlock, refresh
+// or lock of the previous holder. Compare it with the current time to tell whether state cached by the previous
+// holder is still fresh. It is the zero time if the lock item was new.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetLastWrite() time.Time {
+	if m.previousWrite == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.previousWrite)
 }
 
 // GetTimeout retrieves the timeout value set in the Mutex.
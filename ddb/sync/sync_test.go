@@ -1,21 +1,31 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/stretchr/testify/assert"
 	"testing"
 
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 func DeleteTable(m Mutex) {
-	m.DDBSession.DeleteTable(&dynamodb.DeleteTableInput{
-		TableName: aws.String(m.DDBTableName),
-	})
+	m.DeleteTable()
 }
 
 func Test_DDBLock_Default(t *testing.T) {
@@ -89,6 +99,52 @@ func Test_DDBLock_ParallelCount(t *testing.T) {
 	DeleteTable(m)
 }
 
+func Test_LockIncrementUnlock(t *testing.T) {
+	TableName := fmt.Sprintf("Test-LockIncrementUnlock-%d", time.Now().Unix())
+	thisMany := 10
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			m := Mutex{DDBTableName: TableName}
+			_, err := m.LockIncrementUnlock(2)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	m := Mutex{DDBTableName: TableName}
+	value, err := m.LockIncrementUnlock(-1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*thisMany-1), value)
+	assert.NotPanics(t, m.Lock)
+	assert.Nil(t, m.SetValueStringAndUnlock("not a number"))
+	_, err = m.LockIncrementUnlock(1)
+	assert.NotNil(t, err)
+	assert.Equal(t, "not a number", m.LockAndGetValueString()) // Unlocked unchanged
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_DDBLock_ConcurrentTableCreation(t *testing.T) {
+	TableName := fmt.Sprintf("Test-ConcurrentCreate-%d", time.Now().Unix())
+	thisMany := 10
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func(i int) {
+			defer wg.Done()
+			m := Mutex{DDBTableName: TableName, Name: fmt.Sprintf("lock-%d", i)}
+			assert.NotPanics(t, m.Lock)
+			assert.NotPanics(t, m.Unlock)
+		}(i)
+	}
+	wg.Wait()
+	m := Mutex{DDBTableName: TableName}
+	assert.Nil(t, m.initialization())
+	DeleteTable(m)
+}
+
 func Test_ValueTests(t *testing.T) {
 	TableName := fmt.Sprintf("Test-Values-%d", time.Now().Unix())
 	m := Mutex{DDBTableName: TableName}
@@ -111,6 +167,502 @@ func Test_ValueTests(t *testing.T) {
 	DeleteTable(m)
 }
 
+func Test_ValueFloat64(t *testing.T) {
+	m := Mutex{}
+	assert.Equal(t, float64(0), m.GetValueFloat64())
+	a, b := 0.1, 0.2
+	m.SetValueFloat64(a + b)
+	assert.Equal(t, "0.30000000000000004", m.GetValueString())
+	assert.Equal(t, a+b, m.GetValueFloat64())
+	m.SetValueFloat64(-1.5e-300)
+	assert.Equal(t, -1.5e-300, m.GetValueFloat64())
+	m.SetValueInt64(42)
+	assert.Equal(t, float64(42), m.GetValueFloat64())
+	m.SetValueString("forty-two")
+	assert.Panics(t, func() { m.GetValueFloat64() })
+}
+
+func Test_ReadYourWrites(t *testing.T) {
+	TableName := fmt.Sprintf("Test-ReadYourWrites-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	for i := 0; i < 5; i++ {
+		value := fmt.Sprintf("written-%d-%d", i, time.Now().UnixNano())
+		assert.NotPanics(t, m.Lock)
+		m.SetValueString(value)
+		assert.NotPanics(t, m.Unlock)
+		m.SetValueString("local garbage")
+		assert.Equal(t, value, m.LockAndGetValueString())
+		assert.NotPanics(t, m.Unlock)
+		assert.Equal(t, value, n.LockAndGetValueString())
+		assert.NotPanics(t, n.Unlock)
+	}
+	DeleteTable(m)
+}
+
+func Test_SetValueStringAndUnlock(t *testing.T) {
+	TableName := fmt.Sprintf("Test-SetValueStringAndUnlock-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	assert.Equal(t, "", m.LockAndGetValueString())
+	assert.Nil(t, m.SetValueStringAndUnlock("persisted"))
+	assert.Equal(t, "persisted", n.LockAndGetValueString())
+	err := n.SetValueStringAndUnlock(strings.Repeat("x", maxValueSize+1))
+	assert.True(t, errors.Is(err, ErrValueTooLarge))
+	assert.True(t, n.held()) // Still locked, the unlock can be retried
+	n.SetValueString("retried")
+	assert.Nil(t, n.TryUnlock())
+	assert.Equal(t, "retried", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_CompressedValue(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Compressed-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, CompressThreshold: 1024}
+	n := Mutex{DDBTableName: TableName, CompressThreshold: 1024}
+	value := strings.Repeat("0123456789", 100000) // 1 MB, larger than a DynamoDB item
+	assert.NotPanics(t, m.Lock)
+	m.SetValueString(value)
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, value, n.LockAndGetValueString())
+	n.SetValueString("small")
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, "small", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_VerifyIntegrity(t *testing.T) {
+	TableName := fmt.Sprintf("Test-VerifyIntegrity-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, VerifyIntegrity: true}
+	assert.NotPanics(t, m.Lock)
+	assert.Nil(t, m.SetValueStringAndUnlock("trusted"))
+	assert.Equal(t, "trusted", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	_, err := m.DDBSession.UpdateItem(&dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":value": {S: aws.String("tampered")},
+		},
+		Key:              map[string]*dynamodb.AttributeValue{"Name": {S: aws.String(m.Name)}},
+		UpdateExpression: aws.String("SET #value=:value"),
+		ExpressionAttributeNames: map[string]*string{
+			"#value": aws.String("Value"),
+		},
+		TableName: aws.String(TableName),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrIntegrityMismatch, m.lock())
+	assert.False(t, m.locked) // Freed again
+	m.SetValueString("repaired")
+	assert.Nil(t, m.ForceUnlock())
+	assert.Equal(t, "repaired", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_VerifyIntegrity_Offline(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("4")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+		"Value":            {S: aws.String("tampered")},
+		"Checksum":         {S: aws.String(checksum("original"))},
+	}}
+	m := Mutex{DDBSession: writes, VerifyIntegrity: true}
+	held := HeldLocks()
+	acquired, err := m.TryLock()
+	assert.Equal(t, ErrIntegrityMismatch, err)
+	assert.False(t, acquired)
+	assert.False(t, m.locked)
+	assert.Equal(t, held, HeldLocks()) // Not registered
+	// The lock was freed again, without writing the value
+	assert.Equal(t, "SET #id=:zero", *writes.input.UpdateExpression)
+	assert.Equal(t, "4", *writes.input.ExpressionAttributeValues[":fence"].N)
+	assert.NotContains(t, writes.input.ExpressionAttributeValues, ":value")
+}
+
+func Test_InitializeValue(t *testing.T) {
+	TableName := fmt.Sprintf("Test-InitializeValue-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	created, err := m.InitializeValue("seed")
+	assert.Nil(t, err)
+	assert.True(t, created)
+	created, err = m.InitializeValue("another seed")
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "seed", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Once(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Once-%d", time.Now().Unix())
+	thisMany := 5
+	m := Mutex{DDBTableName: TableName}
+	_, err := m.Once(func() error {
+		return errors.New("failed attempt")
+	})
+	assert.NotNil(t, err)
+	runs := int32(0)
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			n := Mutex{DDBTableName: TableName}
+			_, err := n.Once(func() error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), runs)
+	ran, err := m.Once(func() error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.False(t, ran)
+	DeleteTable(m)
+}
+
+func Test_RepairRow(t *testing.T) {
+	TableName := fmt.Sprintf("Test-RepairRow-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Expiry: time.Hour}.WithTimeout(time.Second)
+	assert.Nil(t, m.initialization())
+
+	// A held lock without LastWrite can be taken over by a Mutex with an expiry
+	_, err := m.DDBSession.PutItem(&dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"Name":     {S: aws.String(m.Name)},
+			"LockerID": {N: aws.String("42")},
+		},
+		TableName: aws.String(TableName),
+	})
+	assert.Nil(t, err)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+
+	// A LockerID written as a string blocks everyone until the item is repaired
+	_, err = m.DDBSession.PutItem(&dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"Name":      {S: aws.String(m.Name)},
+			"LockerID":  {S: aws.String("42")},
+			"LastWrite": {N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))},
+		},
+		TableName: aws.String(TableName),
+	})
+	assert.Nil(t, err)
+	assert.Panics(t, m.Lock)
+	assert.Nil(t, m.RepairRow(m.Name))
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+
+	// Well-formed and missing items are left alone
+	assert.Nil(t, m.RepairRow(m.Name))
+	assert.Nil(t, m.RepairRow("missing"))
+	DeleteTable(m)
+}
+
+func Test_InitialJitter(t *testing.T) {
+	jitter := 2 * time.Second
+	TableName := fmt.Sprintf("Test-InitialJitter-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, InitialJitter: jitter}
+	assert.Nil(t, m.initialization())
+	startTime := time.Now()
+	assert.NotPanics(t, m.Lock)
+	assert.True(t, time.Since(startTime) < jitter+time.Second)
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_AtomicIncrement(t *testing.T) {
+	TableName := fmt.Sprintf("Test-AtomicIncrement-%d", time.Now().Unix())
+	thisMany := 20
+	m := Mutex{DDBTableName: TableName}
+	assert.Nil(t, m.initialization())
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := m.AtomicIncrement("counter", 2)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	count, err := m.AtomicIncrement("counter", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*thisMany-1), count)
+	DeleteTable(m)
+}
+
+func Test_WaiterCount(t *testing.T) {
+	TableName := fmt.Sprintf("Test-WaiterCount-%d", time.Now().Unix())
+	thisMany := 3
+	m := Mutex{DDBTableName: TableName, TrackWaiters: true}
+	assert.NotPanics(t, m.Lock)
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			n := Mutex{DDBTableName: TableName, TrackWaiters: true}.WithTimeout(3 * time.Second)
+			assert.Panics(t, n.Lock)
+		}()
+	}
+	time.Sleep(time.Second)
+	count, err := m.WaiterCount(m.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, thisMany, count)
+	wg.Wait()
+	count, err = m.WaiterCount(m.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Cooldown(t *testing.T) {
+	cooldown := 2 * time.Second
+	TableName := fmt.Sprintf("Test-Cooldown-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Cooldown: cooldown}.WithTimeout(time.Second)
+	n := Mutex{DDBTableName: TableName, Cooldown: cooldown}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	assert.Panics(t, m.Lock)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	time.Sleep(cooldown)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Close(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Close-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}.WithTimeout(time.Second)
+	assert.Nil(t, m.Close())
+	assert.NotPanics(t, m.Lock)
+	assert.Nil(t, m.Close())
+	assert.NotPanics(t, n.Lock) // Released by Close
+	assert.NotPanics(t, n.Unlock)
+	assert.Nil(t, n.Close())
+	m.Name = "reconfigured"
+	assert.NotPanics(t, m.Lock) // Reused after Close
+	assert.Nil(t, m.Close())
+	assert.False(t, m.initialized)
+	DeleteTable(m)
+}
+
+func Test_Close_OwnHTTPClient(t *testing.T) {
+	m := Mutex{}
+	assert.Nil(t, m.configure())
+	assert.True(t, m.ownSession)
+	client := m.AWSSession.Config.HTTPClient
+	assert.NotEqual(t, http.DefaultClient, client)
+	assert.NotEqual(t, http.DefaultTransport, client.Transport)
+}
+
+func Test_DiagnoseConflicts(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Diagnose-%d", time.Now().Unix())
+	logger := &testLogger{}
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName, DiagnoseConflicts: true, Logger: logger}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	err := n.lock()
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	holder := fmt.Sprintf("held by %d since ", m.id)
+	assert.Contains(t, err.Error(), holder)
+	diagnoses := []string{}
+	for _, line := range logger.lines {
+		if !strings.HasPrefix(line, "lock Lock is held, retrying in ") {
+			diagnoses = append(diagnoses, line)
+		}
+	}
+	assert.Len(t, diagnoses, 1) // Only the first conflict is diagnosed
+	assert.Contains(t, diagnoses[0], holder)
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Owner(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Owner-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	id, lastWrite, err := n.Owner()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), id)
+	assert.True(t, lastWrite.IsZero())
+	before := time.Now()
+	assert.NotPanics(t, m.Lock)
+	id, lastWrite, err = n.Owner()
+	assert.Nil(t, err)
+	assert.Equal(t, m.id, id)
+	assert.False(t, lastWrite.Before(before))
+	assert.NotPanics(t, m.Unlock)
+	id, _, err = n.Owner()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), id)
+	DeleteTable(m)
+}
+
+func Test_UnlockMissingTable(t *testing.T) {
+	TableName := fmt.Sprintf("Test-MissingTable-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName, Name: "other", UnlockMissingTable: true}
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, n.Lock)
+	DeleteTable(m)
+	assert.Nil(t, m.DDBSession.WaitUntilTableNotExists(&dynamodb.DescribeTableInput{TableName: aws.String(TableName)}))
+	assert.Panics(t, m.Unlock)
+	assert.NotPanics(t, n.Unlock)
+}
+
+func Test_ReapExpired(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-ReapExpired-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Name: "expiring", Expiry: expiry}
+	n := Mutex{DDBTableName: TableName, Name: "held", Expiry: time.Hour}
+	o := Mutex{DDBTableName: TableName, Name: "free", Expiry: expiry}
+	_, err := m.ReapExpired()
+	assert.Nil(t, err)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, o.Lock)
+	assert.NotPanics(t, o.Unlock)
+	time.Sleep(expiry)
+	count, err := m.ReapExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	count, err = m.ReapExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	assert.NotPanics(t, n.Unlock)
+	_, err = (&Mutex{DDBTableName: TableName}).ReapExpired()
+	assert.NotNil(t, err)
+	DeleteTable(m)
+}
+
+func Test_DefaultValue(t *testing.T) {
+	TableName := fmt.Sprintf("Test-DefaultValue-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, DefaultValue: "initial"}
+	n := Mutex{DDBTableName: TableName, Name: "other"}
+	assert.Equal(t, "initial", m.LockAndGetValueString())
+	m.SetValueString("changed")
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, "changed", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	value, _, err := n.GetOrAcquire()
+	assert.Nil(t, err)
+	assert.Equal(t, "0", value) // No default value, the local value is kept
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_TryLockTryUnlock(t *testing.T) {
+	TableName := fmt.Sprintf("Test-TryLock-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	acquired, err := m.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	acquired, err = n.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.NotNil(t, n.TryUnlock())
+	assert.Nil(t, m.TryUnlock())
+	acquired, err = n.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Nil(t, n.TryUnlock())
+	DeleteTable(m)
+}
+
+func Test_AlreadyHeld(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-AlreadyHeld-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName, Name: "expiring", Expiry: expiry}
+	assert.NotPanics(t, m.Lock)
+	assert.Equal(t, ErrAlreadyHeld, m.lock())
+	acquired, err := m.TryLock()
+	assert.Equal(t, ErrAlreadyHeld, err)
+	assert.False(t, acquired)
+	assert.NotPanics(t, m.Unlock)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	assert.NotPanics(t, n.Lock)
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock) // An expired lock is not held anymore
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Retire(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Retire-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	assert.NotPanics(t, m.Lock)
+	assert.Nil(t, m.Retire(m.Name))
+	assert.NotPanics(t, m.Unlock) // The holder can still unlock
+	assert.Equal(t, ErrLockRetired, n.lock())
+	acquired, err := n.TryLock()
+	assert.Equal(t, ErrLockRetired, err)
+	assert.False(t, acquired)
+	assert.Nil(t, m.Unretire(m.Name))
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_RequestID(t *testing.T) {
+	TableName := fmt.Sprintf("Test-RequestID-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, RequestID: "request-1"}
+	retry := Mutex{DDBTableName: TableName, RequestID: "request-1"}.WithTimeout(time.Second)
+	other := Mutex{DDBTableName: TableName, RequestID: "request-2"}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	assert.Panics(t, other.Lock)
+	assert.NotPanics(t, retry.Lock) // Same request, recognized as already acquired
+	assert.Equal(t, m.FencingToken(), retry.FencingToken())
+	assert.NotPanics(t, retry.Unlock)
+	assert.NotPanics(t, other.Lock)
+	assert.NotPanics(t, other.Unlock)
+	DeleteTable(m)
+}
+
+func Test_NotBefore(t *testing.T) {
+	TableName := fmt.Sprintf("Test-NotBefore-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}.WithTimeout(time.Second)
+	opens := time.Now().Add(3 * time.Second)
+	assert.Nil(t, m.SetNotBefore(m.Name, opens))
+	acquired, err := m.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Panics(t, m.Lock)
+	time.Sleep(time.Until(opens))
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	assert.Nil(t, m.SetNotBefore(m.Name, time.Now().Add(time.Hour)))
+	assert.Nil(t, m.SetNotBefore(m.Name, time.Time{}))
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_VerifyPermissions(t *testing.T) {
+	TableName := fmt.Sprintf("Test-VerifyPermissions-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	assert.EqualError(t, m.VerifyPermissions(), fmt.Sprintf("table %s does not exist, permissions cannot be verified", TableName))
+	assert.NotPanics(t, m.Lock)
+	assert.Nil(t, m.VerifyPermissions())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
 func Test_Timeout(t *testing.T) {
 	timeout := 2 * time.Second
 	TableName := fmt.Sprintf("Test-Values-%d", time.Now().Unix())
@@ -128,15 +680,75 @@ func Test_Timeout(t *testing.T) {
 	DeleteTable(m)
 }
 
+func Test_NoTimeout(t *testing.T) {
+	TableName := fmt.Sprintf("Test-NoTimeout-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}.WithTimeout(0)
+	assert.NotPanics(t, m.Lock)
+	locked := make(chan error, 1)
+	go func() {
+		locked <- n.lock()
+	}()
+	held := 6 * time.Second // Longer than the default timeout
+	select {
+	case err := <-locked:
+		t.Fatalf("lock returned while held: %v", err)
+	case <-time.After(held):
+	}
+	assert.NotPanics(t, m.Unlock)
+	assert.Nil(t, <-locked)
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_LockWithError(t *testing.T) {
+	TableName := fmt.Sprintf("Test-LockWithError-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}.WithTimeout(time.Second)
+	assert.Nil(t, m.LockWithError())
+	assert.Equal(t, ErrLockTimeout, n.LockWithError())
+	assert.Equal(t, ErrNotOwner, n.UnlockWithError())
+	assert.Nil(t, m.UnlockWithError())
+	assert.Nil(t, n.LockWithError())
+	assert.Nil(t, n.UnlockWithError())
+	DeleteTable(m)
+}
+
+func Test_LockContext(t *testing.T) {
+	TableName := fmt.Sprintf("Test-LockContext-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}.WithTimeout(0)
+	assert.NotPanics(t, m.Lock)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	started := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, n.LockContext(ctx))
+	assert.True(t, time.Since(started) < 2*time.Second)
+	assert.NotPanics(t, m.Unlock)
+	assert.Nil(t, n.LockContext(context.Background()))
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_Sleep(t *testing.T) {
+	assert.Nil(t, sleep(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started := time.Now()
+	assert.Equal(t, context.Canceled, sleep(ctx, time.Hour))
+	assert.True(t, time.Since(started) < time.Second)
+}
+
 func Test_Expiry(t *testing.T) {
 	timeout := 1 * time.Second
 	expiry := 3 * time.Second
 	TableName := fmt.Sprintf("Test-Expiry-%d", time.Now().Unix())
-	m := Mutex{DDBTableName: TableName, Expiry: expiry}.WithTimeout(timeout)
-	n := Mutex{DDBTableName: TableName, Expiry: expiry}.WithTimeout(timeout)
+	clock := &fakeClock{now: time.Now(), step: 100 * time.Millisecond}
+	m := Mutex{DDBTableName: TableName, Expiry: expiry, Clock: clock}.WithTimeout(timeout)
+	n := Mutex{DDBTableName: TableName, Expiry: expiry, Clock: clock}.WithTimeout(timeout)
 	assert.NotPanics(t, m.Lock)
 	assert.Panics(t, n.Lock)
-	time.Sleep(expiry)
+	clock.Advance(expiry)
 	startTime := time.Now()
 	assert.NotPanics(t, n.Lock)
 	endTime := time.Now()
@@ -146,6 +758,766 @@ func Test_Expiry(t *testing.T) {
 	DeleteTable(m)
 }
 
+func Test_GetOrAcquire(t *testing.T) {
+	TableName := fmt.Sprintf("Test-GetOrAcquire-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	value, acquired, err := m.GetOrAcquire()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "0", value)
+	m.SetValueString("leader")
+	value, acquired, err = n.GetOrAcquire()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, "", value) // Nothing was written to the database yet
+	assert.NotPanics(t, m.Unlock)
+	value, acquired, err = n.GetOrAcquire()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "leader", value)
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+type testLogger struct {
+	lines []string
+	mu    sync.Mutex
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func Test_DebugLogging(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-DebugLogging-%d", time.Now().Unix())
+	logger := &testLogger{}
+	m := Mutex{DDBTableName: TableName, Expiry: expiry}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry, Logger: logger, DisableJitter: true}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	assert.Panics(t, n.Lock)
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	lines := strings.Join(logger.lines, "\n")
+	assert.Contains(t, lines, "lock Lock is held, retrying in 100ms")
+	assert.Contains(t, lines, fmt.Sprintf("took over lock Lock from %d", m.id))
+	assert.Contains(t, lines, fmt.Sprintf("fencing token %d", n.FencingToken()))
+	assert.Equal(t, "unlocked Lock", logger.lines[len(logger.lines)-1])
+	DeleteTable(m)
+}
+
+func Test_LogConsumedCapacity(t *testing.T) {
+	TableName := fmt.Sprintf("Test-Capacity-%d", time.Now().Unix())
+	logger := &testLogger{}
+	m := Mutex{DDBTableName: TableName, Logger: logger, LogConsumedCapacity: true}
+	n := Mutex{DDBTableName: TableName, Logger: logger, LogConsumedCapacity: true}
+	assert.NotPanics(t, m.Lock)
+	acquired, err := n.tryAcquire()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.NotPanics(t, m.Unlock)
+	if assert.Len(t, logger.lines, 5) {
+		assert.Equal(t, "op=tryLock wcu=1.0 result=ok", logger.lines[0])
+		assert.Regexp(t, `^locked Lock after \S+, fencing token \d+$`, logger.lines[1])
+		assert.Equal(t, []string{
+			"op=tryLock wcu=- result=conflict",
+			"op=tryUnlock wcu=1.0 result=ok",
+			"unlocked Lock",
+		}, logger.lines[2:])
+	}
+	DeleteTable(m)
+}
+
+func Test_ExpirySkewTolerance(t *testing.T) {
+	timeout := 1 * time.Second
+	expiry := 2 * time.Second
+	tolerance := 2 * time.Second
+	TableName := fmt.Sprintf("Test-ExpirySkew-%d", time.Now().Unix())
+	clock := &fakeClock{now: time.Now(), step: 100 * time.Millisecond}
+	m := Mutex{DDBTableName: TableName, Expiry: expiry, ExpirySkewTolerance: tolerance, Clock: clock}.WithTimeout(timeout)
+	n := Mutex{DDBTableName: TableName, Expiry: expiry, ExpirySkewTolerance: tolerance, Clock: clock}.WithTimeout(timeout)
+	assert.NotPanics(t, m.Lock)
+	clock.Advance(expiry)
+	assert.Panics(t, n.Lock) // Expired, but still within the tolerance
+	clock.Advance(tolerance)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_UnknownPartition(t *testing.T) {
+	m := Mutex{AWSPartition: "aws-mars"}
+	assert.EqualError(t, m.initialization(), "unknown AWS partition: aws-mars")
+	assert.Panics(t, m.Lock)
+}
+
+func Test_InvalidTableName(t *testing.T) {
+	m := Mutex{DDBTableName: "my locks!"}
+	assert.EqualError(t, m.initialization(), `invalid table name "my locks!": use 3-255 characters of a-z, A-Z, 0-9, '_', '-' and '.'`)
+	assert.Panics(t, m.Lock)
+}
+
+func Test_TableNameTemplate(t *testing.T) {
+	assert.Equal(t, "Locks-acme", expandTableName("Locks-{tenant}", "acme", "Lock"))
+	assert.Equal(t, "acme.jobs.jobs", expandTableName("{tenant}.{name}.{name}", "acme", "jobs"))
+	assert.Equal(t, "Locks", expandTableName("Locks", "acme", "Lock"))
+	m := Mutex{DDBTableName: "Ignored", TableNameTemplate: "Locks {tenant}", Tenant: "acme"}
+	assert.EqualError(t, m.initialization(), `invalid table name "Locks acme": use 3-255 characters of a-z, A-Z, 0-9, '_', '-' and '.'`)
+}
+
+func Test_BillingMode(t *testing.T) {
+	m := Mutex{DDBTableName: "Locks", ReadCapacityUnits: 2, WriteCapacityUnits: 20}
+	input := m.createTableInput()
+	assert.Nil(t, input.BillingMode)
+	assert.Equal(t, int64(2), *input.ProvisionedThroughput.ReadCapacityUnits)
+	assert.Equal(t, int64(20), *input.ProvisionedThroughput.WriteCapacityUnits)
+	m.BillingMode = dynamodb.BillingModePayPerRequest
+	input = m.createTableInput()
+	assert.Equal(t, dynamodb.BillingModePayPerRequest, *input.BillingMode)
+	assert.Nil(t, input.ProvisionedThroughput)
+	m = Mutex{BillingMode: "FREE"}
+	assert.EqualError(t, m.initialization(), "unknown billing mode: FREE")
+}
+
+func Test_Tags(t *testing.T) {
+	m := Mutex{DDBTableName: "Locks"}
+	assert.Nil(t, m.createTableInput().Tags)
+	m.Tags = map[string]string{"team": "payments", "cost-center": "42"}
+	assert.Equal(t, []*dynamodb.Tag{
+		{Key: aws.String("cost-center"), Value: aws.String("42")},
+		{Key: aws.String("team"), Value: aws.String("payments")},
+	}, m.createTableInput().Tags)
+}
+
+func Test_SSESpecification(t *testing.T) {
+	m := Mutex{DDBTableName: "Locks"}
+	assert.Nil(t, m.createTableInput().SSESpecification)
+	m.SSEEnabled = true
+	assert.Equal(t, &dynamodb.SSESpecification{Enabled: aws.Bool(true)}, m.createTableInput().SSESpecification)
+	m = Mutex{DDBTableName: "Locks", SSEKMSKeyID: "alias/locks"}
+	assert.Equal(t, &dynamodb.SSESpecification{
+		Enabled:        aws.Bool(true),
+		KMSMasterKeyId: aws.String("alias/locks"),
+		SSEType:        aws.String(dynamodb.SSETypeKms),
+	}, m.createTableInput().SSESpecification)
+}
+
+func Test_KeyAttributeName(t *testing.T) {
+	m := Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, "Name", m.KeyAttributeName)
+	m = Mutex{KeyAttributeName: "pk", DDBTableName: "Items", DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	input := m.createTableInput()
+	assert.Equal(t, "pk", *input.AttributeDefinitions[0].AttributeName)
+	assert.Equal(t, "pk", *input.KeySchema[0].AttributeName)
+	assert.Equal(t, "Lock", *m.key(m.Name)["pk"].S)
+	m = Mutex{KeyAttributeName: "Value", DDBTableName: "Items"}
+	assert.EqualError(t, m.initialization(), `invalid key attribute name "Value": use at most 255 bytes, other than the attributes of a lock item`)
+	m = Mutex{KeyAttributeName: strings.Repeat("k", 256), DDBTableName: "Items"}
+	assert.NotNil(t, m.initialization())
+}
+
+func Test_AttributeNames(t *testing.T) {
+	writes := &recordingWrites{}
+	m := Mutex{DDBSession: writes, ValueAttributeName: "data", LastWriteAttributeName: "updated_at", LockerIDAttributeName: "owner"}
+	m.SetValueString("42")
+	assert.Nil(t, m.ForceUnlock())
+	assert.Equal(t, "data", *writes.input.ExpressionAttributeNames["#value"])
+	assert.Equal(t, "updated_at", *writes.input.ExpressionAttributeNames["#lastwrite"])
+	assert.Equal(t, "owner", *writes.input.ExpressionAttributeNames["#id"])
+	value, ok, err := decodeValue(map[string]*dynamodb.AttributeValue{"data": {S: aws.String("42")}}, m.ValueAttributeName)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "42", value)
+
+	m = Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, []string{"Value", "LastWrite", "LockerID"}, []string{m.ValueAttributeName, m.LastWriteAttributeName, m.LockerIDAttributeName})
+	m = Mutex{ValueAttributeName: "my value"}
+	assert.EqualError(t, m.initialization(), `invalid value attribute name "my value": use 1-255 characters of a-z, A-Z, 0-9, '_', '-' and '.', other than the other attributes of a lock item`)
+	m = Mutex{LockerIDAttributeName: "Fence"}
+	assert.NotNil(t, m.initialization())
+	m = Mutex{LastWriteAttributeName: "Value"}
+	assert.NotNil(t, m.initialization())
+	m = Mutex{KeyAttributeName: "pk", DDBTableName: "Items", ValueAttributeName: "pk"}
+	assert.NotNil(t, m.initialization())
+}
+
+func Test_CapacityUnitsDefault(t *testing.T) {
+	m := Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, int64(5), m.ReadCapacityUnits)
+	assert.Equal(t, int64(5), m.WriteCapacityUnits)
+}
+
+func Test_Endpoint(t *testing.T) {
+	m := Mutex{Endpoint: "http://localhost:8000", DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, "http://localhost:8000", *m.AWSSession.Config.Endpoint)
+	n := Mutex{Endpoint: "http://localhost:8000", AWSSession: m.AWSSession.Copy(&aws.Config{Endpoint: aws.String("")}), DDBSession: activeTable{}}
+	assert.Nil(t, n.initialization())
+	assert.Equal(t, "", *n.AWSSession.Config.Endpoint) // Passed in sessions are untouched
+}
+
+func Test_AWSProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsync")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("[locks]\naws_access_key_id = AKIDLOCKS\naws_secret_access_key = secret\n"), 0600))
+	defer os.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.Getenv("AWS_SHARED_CREDENTIALS_FILE"))
+	defer os.Setenv("AWS_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+	defer os.Setenv("AWS_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", file)
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	m := Mutex{AWSProfile: "locks", AWSRegion: "eu-west-1", DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	value, err := m.AWSSession.Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKIDLOCKS", value.AccessKeyID)
+	assert.Equal(t, "eu-west-1", *m.AWSSession.Config.Region)
+
+	n := Mutex{AWSProfile: "missing", DDBSession: activeTable{}}
+	assert.Nil(t, n.initialization())
+	_, err = n.AWSSession.Config.Credentials.Get()
+	assert.NotNil(t, err) // Environment variables are not used instead
+}
+
+func Test_RoleARN(t *testing.T) {
+	m := Mutex{RoleARN: "arn:aws:iam::123456789012:role/locks", ExternalID: "dsync"}
+	assert.Nil(t, m.configure())
+	client := m.DDBSession.(*dynamodb.DynamoDB)
+	assert.NotEqual(t, m.AWSSession.Config.Credentials, client.Config.Credentials)
+	n := Mutex{}
+	assert.Nil(t, n.configure())
+	assert.Equal(t, n.AWSSession.Config.Credentials, n.DDBSession.(*dynamodb.DynamoDB).Config.Credentials)
+}
+
+func Test_SanitizeTableName(t *testing.T) {
+	assert.Equal(t, "my_locks_", sanitizeTableName("my locks!"))
+	assert.Equal(t, "Locks-v1.2_prod", sanitizeTableName("Locks-v1.2_prod"))
+	assert.Equal(t, "a__", sanitizeTableName("a"))
+	assert.Equal(t, "___", sanitizeTableName("é"))
+	assert.Equal(t, 255, len(sanitizeTableName(strings.Repeat("x", 300))))
+}
+
+func Test_NextTablePollInterval(t *testing.T) {
+	assert.Equal(t, 150*time.Millisecond, nextTablePollInterval(100*time.Millisecond))
+	assert.Equal(t, 3*time.Second, nextTablePollInterval(2*time.Second))
+	assert.Equal(t, maxTablePollInterval, nextTablePollInterval(4*time.Second))
+	assert.Equal(t, maxTablePollInterval, nextTablePollInterval(maxTablePollInterval))
+}
+
+func Test_CapBackoff(t *testing.T) {
+	assert.Equal(t, 80*time.Millisecond, capBackoff(80*time.Millisecond, 0, time.Second))
+	assert.Equal(t, 20*time.Millisecond, capBackoff(80*time.Millisecond, 20*time.Millisecond, time.Second))
+	assert.Equal(t, 10*time.Millisecond, capBackoff(80*time.Millisecond, 20*time.Millisecond, 10*time.Millisecond))
+	assert.Equal(t, time.Duration(0), capBackoff(80*time.Millisecond, 0, -time.Second))
+}
+
+func Test_LastWrite(t *testing.T) {
+	written := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	av := FormatLastWrite(written)
+	assert.Equal(t, strconv.FormatInt(written.UnixNano(), 10), *av.N)
+	parsed, err := ParseLastWrite(av)
+	assert.Nil(t, err)
+	assert.True(t, parsed.Equal(written))
+	_, err = ParseLastWrite(nil)
+	assert.NotNil(t, err)
+	_, err = ParseLastWrite(&dynamodb.AttributeValue{S: aws.String("yesterday")})
+	assert.NotNil(t, err)
+	_, err = ParseLastWrite(&dynamodb.AttributeValue{N: aws.String("1.5")})
+	assert.NotNil(t, err)
+}
+
+func Test_RetryDelay(t *testing.T) {
+	m := Mutex{}
+	for i := 0; i < 100; i++ {
+		delay := m.retryDelay(i)
+		assert.True(t, delay >= 0 && delay < maxRetryDelay)
+	}
+	m.DisableJitter = true
+	assert.Equal(t, maxRetryDelay, m.retryDelay(0))
+}
+
+func Test_RetryBackoff(t *testing.T) {
+	m := Mutex{RetryBackoff: 5 * time.Millisecond, DisableJitter: true}
+	assert.Equal(t, 5*time.Millisecond, m.retryDelay(0))
+	assert.Equal(t, 10*time.Millisecond, m.retryDelay(1))
+	assert.Equal(t, 320*time.Millisecond, m.retryDelay(6))
+	assert.Equal(t, maxRetryBackoff, m.retryDelay(7))
+	assert.Equal(t, maxRetryBackoff, m.retryDelay(1000))
+	m.MaxBackoff = 50 * time.Millisecond
+	assert.Equal(t, 50*time.Millisecond, m.retryDelay(4))
+	m.DisableJitter = false
+	for i := 0; i < 100; i++ {
+		delay := m.retryDelay(i)
+		assert.True(t, delay >= 0 && delay < m.retryBound(i))
+	}
+}
+
+// activeTable is a DynamoDB client whose tables always exist. Other calls panic.
+type activeTable struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (activeTable) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{
+		Table: &dynamodb.TableDescription{TableStatus: aws.String(dynamodb.TableStatusActive)},
+	}, nil
+}
+
+// throttledTable is a DynamoDB client whose first DescribeTable calls are throttled.
+type throttledTable struct {
+	activeTable
+	throttled int
+}
+
+func (t *throttledTable) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	if t.throttled > 0 {
+		t.throttled--
+		return nil, awserr.New("ThrottlingException", "Rate exceeded", nil)
+	}
+	return t.activeTable.DescribeTable(input)
+}
+
+// stuckTable is a DynamoDB client whose tables never leave the CREATING state.
+type stuckTable struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (stuckTable) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{
+		Table: &dynamodb.TableDescription{TableStatus: aws.String(dynamodb.TableStatusCreating)},
+	}, nil
+}
+
+func Test_TableReadyTimeout(t *testing.T) {
+	m := Mutex{DDBSession: stuckTable{}, TablePollInterval: time.Millisecond, TableReadyTimeout: 50 * time.Millisecond}
+	err := m.initialization()
+	assert.True(t, errors.Is(err, ErrTableNotReady))
+	assert.EqualError(t, err, "table is not active: table Locks did not become active in 50ms")
+	n := Mutex{DDBSession: activeTable{}}
+	assert.Nil(t, n.initialization())
+	assert.Equal(t, time.Minute, n.TableReadyTimeout)
+}
+
+func Test_DescribeTableThrottled(t *testing.T) {
+	client := &throttledTable{throttled: 3}
+	metrics := &testMetrics{}
+	m := Mutex{DDBSession: client, TablePollInterval: time.Millisecond, Metrics: metrics}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, 0, client.throttled)
+	assert.Equal(t, int64(3), metrics.throttle)
+}
+
+// replica is a DynamoDB client serving reads from a fixed item.
+type replica struct {
+	activeTable
+	item  map[string]*dynamodb.AttributeValue
+	input *dynamodb.GetItemInput
+}
+
+func (r *replica) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	r.input = input
+	return &dynamodb.GetItemOutput{Item: r.item}, nil
+}
+
+func Test_DDBReadSession(t *testing.T) {
+	read := &replica{item: map[string]*dynamodb.AttributeValue{"Waiters": {N: aws.String("3")}}}
+	m := Mutex{DDBSession: activeTable{}, DDBReadSession: read}
+	count, err := m.WaiterCount("queue")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, "queue", *read.input.Key["Name"].S)
+	assert.False(t, *read.input.ConsistentRead)
+}
+
+func Test_GetValueConsistent(t *testing.T) {
+	table := &replica{item: testItem("42", "")}
+	m := Mutex{Name: "counter", DDBSession: table, DDBReadSession: &replica{}}
+	value, err := m.GetValueConsistent()
+	assert.Nil(t, err)
+	assert.Equal(t, "42", value)
+	assert.Equal(t, "counter", *table.input.Key["Name"].S)
+	assert.True(t, *table.input.ConsistentRead) // Never through DDBReadSession
+	assert.Equal(t, "0", m.GetValueString())    // The held value is unchanged
+	table.item = nil
+	m.DefaultValue = "empty"
+	value, err = m.GetValueConsistent()
+	assert.Nil(t, err)
+	assert.Equal(t, "empty", value)
+}
+
+func Test_Peek(t *testing.T) {
+	table := &replica{}
+	m := Mutex{DDBSession: table}
+	held, owner, _, err := m.Peek()
+	assert.Nil(t, err)
+	assert.False(t, held) // No item
+	assert.Equal(t, int64(0), owner)
+	table.item = map[string]*dynamodb.AttributeValue{
+		"LockerID":  {N: aws.String("0")},
+		"LastWrite": FormatLastWrite(time.Now()),
+	}
+	held, _, _, err = m.Peek()
+	assert.Nil(t, err)
+	assert.False(t, held) // Unlocked
+	table.item["LockerID"] = &dynamodb.AttributeValue{N: aws.String("7")}
+	held, owner, expiresAt, err := m.Peek()
+	assert.Nil(t, err)
+	assert.True(t, held)
+	assert.Equal(t, int64(7), owner)
+	assert.True(t, expiresAt.IsZero())
+	written := time.Now().Add(-time.Minute)
+	table.item["LastWrite"] = FormatLastWrite(written)
+	m.Expiry = time.Hour
+	held, _, expiresAt, err = m.Peek()
+	assert.Nil(t, err)
+	assert.True(t, held)
+	assert.True(t, expiresAt.Equal(written.Add(time.Hour)))
+	m.Expiry = time.Second
+	held, owner, _, err = m.Peek()
+	assert.Nil(t, err)
+	assert.False(t, held) // Expired
+	assert.Equal(t, int64(7), owner)
+}
+
+// failingWrites is a DynamoDB client whose writes fail with a fixed error and whose items are empty.
+type failingWrites struct {
+	activeTable
+	err    error
+	writes int
+}
+
+func (f *failingWrites) UpdateItem(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.writes++
+	return nil, f.err
+}
+
+func (f *failingWrites) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// recordingWrites is a DynamoDB client whose writes succeed and are recorded.
+type recordingWrites struct {
+	activeTable
+	input      *dynamodb.UpdateItemInput
+	attributes map[string]*dynamodb.AttributeValue
+}
+
+func (r *recordingWrites) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	r.input = input
+	return &dynamodb.UpdateItemOutput{Attributes: r.attributes}, nil
+}
+
+func Test_ForceUnlock_Offline(t *testing.T) {
+	writes := &recordingWrites{}
+	m := Mutex{DDBSession: writes, Cooldown: time.Minute}
+	m.SetValueString("reset")
+	assert.Nil(t, m.ForceUnlock())
+	assert.Nil(t, writes.input.ConditionExpression)
+	assert.Equal(t, "reset", *writes.input.ExpressionAttributeValues[":value"].S)
+	assert.Equal(t, "0", *writes.input.ExpressionAttributeValues[":zero"].N)
+	// DynamoDB rejects placeholders that are not used by the expressions
+	for _, unused := range []string{":id", ":fence", ":cooldownuntil"} {
+		assert.NotContains(t, writes.input.ExpressionAttributeValues, unused)
+	}
+	for _, unused := range []string{"#name", "#fence", "#releasedby"} {
+		assert.NotContains(t, writes.input.ExpressionAttributeNames, unused)
+	}
+}
+
+func Test_ForceUnlock_KeepsValue(t *testing.T) {
+	writes := &recordingWrites{}
+	m := Mutex{DDBSession: writes, VerifyIntegrity: true}
+	assert.Nil(t, m.ForceUnlock())
+	assert.Equal(t, "SET #lastwrite=:lastwrite, #id=:zero REMOVE #requestid", *writes.input.UpdateExpression)
+	for _, unused := range []string{"#value", "#encoding", "#checksum", "#bytes"} {
+		assert.NotContains(t, writes.input.ExpressionAttributeNames, unused)
+	}
+	assert.NotContains(t, writes.input.ExpressionAttributeValues, ":value")
+	m.SetValueBytes([]byte{1})
+	assert.Nil(t, m.ForceUnlock())
+	assert.Contains(t, *writes.input.UpdateExpression, "#value=:value")
+	assert.Nil(t, m.ForceUnlock()) // Written once, released since
+	assert.NotContains(t, *writes.input.UpdateExpression, "#value")
+}
+
+func Test_ForceUnlock(t *testing.T) {
+	TableName := fmt.Sprintf("Test-ForceUnlock-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	admin := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName}
+	assert.NotPanics(t, m.Lock)
+	m.SetValueString("lost")
+	admin.SetValueString("reset")
+	assert.Nil(t, admin.ForceUnlock())
+	assert.Equal(t, "reset", n.LockAndGetValueString())
+	assert.Equal(t, ErrFencedOut, m.UnlockWithError())
+	assert.NotPanics(t, n.Unlock)
+	DeleteTable(m)
+}
+
+func Test_ValueBytes(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"Value":            {S: aws.String("0")},
+		"Bytes":            {B: []byte{0, 1, 2}},
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes}
+	acquired, err := m.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, []byte{0, 1, 2}, m.GetValueBytes())
+	m.SetValueBytes(make([]byte, maxValueSize+1))
+	assert.Equal(t, ErrValueTooLarge, m.UnlockWithError())
+	m.SetValueBytes([]byte{0xff})
+	assert.Nil(t, m.UnlockWithError())
+	assert.Contains(t, *writes.input.UpdateExpression, "#bytes=:bytes")
+	assert.Equal(t, []byte{0xff}, writes.input.ExpressionAttributeValues[":bytes"].B)
+	m.SetValueBytes(nil)
+	assert.Nil(t, m.ForceUnlock())
+	assert.Contains(t, *writes.input.UpdateExpression, "#bytes")
+	assert.NotContains(t, *writes.input.UpdateExpression, ":bytes") // Removed
+	assert.NotContains(t, writes.input.ExpressionAttributeValues, ":bytes")
+}
+
+func Test_ValueBytes_Removed(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"Bytes":            {B: []byte{0, 1, 2}},
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.Nil(t, m.LockWithError())
+	assert.Equal(t, []byte{0, 1, 2}, m.GetValueBytes())
+	assert.Nil(t, m.UnlockWithError())
+	// Another holder unlocks without bytes, which removes them
+	delete(writes.attributes, "Bytes")
+	writes.attributes["Fence"] = &dynamodb.AttributeValue{N: aws.String("3")}
+	assert.Nil(t, m.LockWithError())
+	assert.Empty(t, m.GetValueBytes())
+	assert.Nil(t, m.UnlockWithError())
+	assert.NotContains(t, writes.input.ExpressionAttributeValues, ":bytes") // Not brought back
+}
+
+// unreachableTable is a DynamoDB client whose DescribeTable calls fail.
+type unreachableTable struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (unreachableTable) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return nil, awserr.New(dynamodb.ErrCodeInternalServerError, "Internal server error", nil)
+}
+
+func Test_WrappedErrors(t *testing.T) {
+	var aerr awserr.Error
+	m := Mutex{DDBSession: unreachableTable{}}
+	_, err := m.TryLock()
+	assert.True(t, errors.As(err, &aerr))
+	assert.Equal(t, dynamodb.ErrCodeInternalServerError, aerr.Code())
+	assert.False(t, errors.Is(err, ErrLockTimeout))
+
+	expired := &failingWrites{err: awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)}
+	n := Mutex{DDBSession: expired}
+	_, err = n.TryLock()
+	assert.True(t, errors.Is(err, ErrCredentialsExpired))
+	assert.True(t, errors.As(err, &aerr))
+	assert.Equal(t, "ExpiredTokenException", aerr.Code())
+	assert.Equal(t, 2, expired.writes) // Retried once with refreshed credentials
+}
+
+func Test_GetLastWrite(t *testing.T) {
+	previous := time.Unix(1000, 0)
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":             {N: aws.String("1")},
+		"LastWrite":         FormatLastWrite(time.Now()),
+		"PreviousLockerID":  {N: aws.String("0")},
+		"PreviousLastWrite": FormatLastWrite(previous),
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.True(t, m.GetLastWrite().IsZero())
+	assert.Nil(t, m.LockWithError())
+	assert.Contains(t, *writes.input.UpdateExpression, "#prevwrite=if_not_exists(#lastwrite, :zero)")
+	assert.Equal(t, previous, m.GetLastWrite())
+	assert.Nil(t, m.UnlockWithError())
+	writes.attributes["PreviousLastWrite"] = &dynamodb.AttributeValue{N: aws.String("0")}
+	assert.Nil(t, m.LockWithError())
+	assert.True(t, m.GetLastWrite().IsZero()) // A new lock item
+}
+
+// expiringWrites is a DynamoDB client whose first write fails with expired credentials.
+type expiringWrites struct {
+	recordingWrites
+	writes int
+}
+
+func (e *expiringWrites) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	e.writes++
+	if e.writes == 1 {
+		return nil, awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)
+	}
+	return e.recordingWrites.UpdateItem(input)
+}
+
+func Test_CredentialsRefreshed(t *testing.T) {
+	writes := &expiringWrites{recordingWrites: recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}}
+	creds := credentials.NewStaticCredentials("id", "secret", "")
+	_, err := creds.Get()
+	assert.Nil(t, err)
+	logger := &testLogger{}
+	m := Mutex{DDBSession: writes, AWSSession: &session.Session{Config: &aws.Config{Credentials: creds}}, Logger: logger}
+	acquired, err := m.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, 2, writes.writes) // Retried once
+	assert.True(t, creds.IsExpired()) // Refreshed before the retry
+	assert.Contains(t, strings.Join(logger.lines, "\n"), "credentials expired, refreshing")
+}
+
+func Test_LockDeadline(t *testing.T) {
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	m := Mutex{DDBSession: held, MaxBackoff: time.Millisecond}.WithTimeout(10 * time.Millisecond)
+	assert.Equal(t, ErrLockTimeout, m.LockDeadline(time.Now().Add(-time.Second)))
+	assert.Equal(t, 0, held.writes) // Passed deadlines fail right away
+	started := time.Now()
+	assert.Equal(t, ErrLockTimeout, m.LockDeadline(started.Add(100*time.Millisecond)))
+	assert.True(t, time.Since(started) >= 100*time.Millisecond) // The deadline replaces the timeout
+	assert.True(t, held.writes > 1)
+}
+
+func Test_MaxAttempts(t *testing.T) {
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	m := Mutex{DDBSession: held, MaxBackoff: time.Millisecond, MaxAttempts: 3}.WithTimeout(time.Minute)
+	assert.Equal(t, ErrMaxAttempts, m.LockWithError())
+	assert.Equal(t, 3, held.writes)
+	// The timeout elapses first
+	held.writes = 0
+	m = Mutex{DDBSession: held, MaxBackoff: time.Millisecond, MaxAttempts: 100, Clock: &fakeClock{now: time.Unix(1000, 0), step: time.Second}}.WithTimeout(2 * time.Second)
+	assert.Equal(t, ErrLockTimeout, m.LockWithError())
+	assert.True(t, held.writes < 100)
+}
+
+func Test_WithLock(t *testing.T) {
+	writes := &recordingWrites{attributes: map[string]*dynamodb.AttributeValue{
+		"Fence":            {N: aws.String("1")},
+		"LastWrite":        FormatLastWrite(time.Now()),
+		"PreviousLockerID": {N: aws.String("0")},
+	}}
+	m := Mutex{DDBSession: writes}
+	assert.Nil(t, m.WithLock(func() error {
+		assert.True(t, m.locked)
+		m.SetValueString("done")
+		return nil
+	}))
+	assert.False(t, m.locked)
+	assert.Equal(t, "done", *writes.input.ExpressionAttributeValues[":value"].S)
+	failed := errors.New("failed")
+	assert.Equal(t, failed, m.WithLock(func() error { return failed }))
+	assert.False(t, m.locked)
+	assert.Panics(t, func() {
+		_ = m.WithLock(func() error { panic("critical section") })
+	})
+	assert.False(t, m.locked) // Unlocked before the panic continued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, m.WithLockContext(ctx, func() error { return nil })) // Free locks are acquired at once
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	n := Mutex{DDBSession: held}
+	ran := false
+	assert.Equal(t, context.Canceled, n.WithLockContext(ctx, func() error { ran = true; return nil }))
+	assert.False(t, ran)
+}
+
+func Test_TryLock_Offline(t *testing.T) {
+	held := &failingWrites{err: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)}
+	m := Mutex{DDBSession: held}
+	acquired, err := m.TryLock()
+	assert.Nil(t, err) // Held by someone else is not an error
+	assert.False(t, acquired)
+	assert.Equal(t, 1, held.writes)
+
+	broken := &failingWrites{err: awserr.New(dynamodb.ErrCodeInternalServerError, "Internal server error", nil)}
+	m = Mutex{DDBSession: broken}
+	acquired, err = m.TryLock()
+	assert.True(t, isErrCode(err, dynamodb.ErrCodeInternalServerError))
+	assert.False(t, acquired)
+	assert.Equal(t, 1, broken.writes)
+}
+
+func Test_IsCredentialsExpired(t *testing.T) {
+	assert.True(t, isCredentialsExpired(awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)))
+	assert.True(t, isCredentialsExpired(awserr.New("ExpiredToken", "The provided token has expired", nil)))
+	assert.False(t, isCredentialsExpired(awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)))
+	assert.False(t, isCredentialsExpired(errors.New("ExpiredTokenException")))
+	assert.False(t, isCredentialsExpired(nil))
+}
+
+func Test_StaleGracePeriod(t *testing.T) {
+	grace := 2 * time.Second
+	TableName := fmt.Sprintf("Test-StaleGracePeriod-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName}
+	n := Mutex{DDBTableName: TableName, StaleGracePeriod: grace}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock) // Crashed holder without expiry
+	assert.Panics(t, n.Lock)
+	time.Sleep(grace)
+	assert.NotPanics(t, n.Lock)
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, ErrFencedOut, m.unlock())
+	DeleteTable(m)
+}
+
+func Test_FencingToken(t *testing.T) {
+	expiry := 2 * time.Second
+	TableName := fmt.Sprintf("Test-Fencing-%d", time.Now().Unix())
+	m := Mutex{DDBTableName: TableName, Expiry: expiry}
+	n := Mutex{DDBTableName: TableName, Expiry: expiry}
+	assert.NotPanics(t, m.Lock)
+	first := m.FencingToken()
+	assert.NotPanics(t, m.Unlock)
+	assert.NotPanics(t, m.Lock)
+	assert.True(t, m.FencingToken() > first)
+	m.SetValueString("stale")
+	time.Sleep(expiry) // m expires
+	assert.NotPanics(t, n.Lock)
+	assert.True(t, n.FencingToken() > m.FencingToken())
+	n.SetValueString("fresh")
+	assert.Equal(t, ErrFencedOut, m.unlock())
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, "fresh", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	DeleteTable(m)
+}
+
+func Test_ExpiresAt(t *testing.T) {
+	m := Mutex{Expiry: time.Minute}
+	_, ok := m.ExpiresAt()
+	assert.False(t, ok)
+	m.locked = true
+	m.lastWrite = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	expiresAt, ok := m.ExpiresAt()
+	assert.True(t, ok)
+	assert.True(t, expiresAt.Equal(time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)))
+	m.Expiry = 0
+	_, ok = m.ExpiresAt()
+	assert.False(t, ok)
+}
+
 func ExampleMutex_Lock() {
 	m := Mutex{}
 	m.Lock()
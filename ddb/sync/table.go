@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DeleteTable deletes the DynamoDB table of the Mutex, with all the locks and values in it. It is meant for the
+// teardown of integration tests and ephemeral environments, and is never called by the package itself. It does not
+// create the table first, like other methods do, and a table that does not exist is not an error.
+//
+// DynamoDB deletes tables in the background; see DeleteTableAndWait. The Mutex is initialized anew on its next use,
+// which creates the table again.
+func (m *Mutex) DeleteTable() (err error) {
+	err = m.configure()
+	if err != nil {
+		return
+	}
+	_, err = m.DDBSession.DeleteTable(&dynamodb.DeleteTableInput{
+		TableName: aws.String(m.DDBTableName),
+	})
+	if isErrCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		err = nil
+	}
+	if err == nil {
+		m.initialized = false
+	}
+	return
+}
+
+// DeleteTableAndWait deletes the DynamoDB table of the Mutex like DeleteTable, and waits until the deletion is
+// complete, so a table with the same name can be created again.
+func (m *Mutex) DeleteTableAndWait() (err error) {
+	err = m.DeleteTable()
+	if err != nil {
+		return
+	}
+	return m.DDBSession.WaitUntilTableNotExists(&dynamodb.DescribeTableInput{
+		TableName: aws.String(m.DDBTableName),
+	})
+}
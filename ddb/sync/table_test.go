@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// deletedTable is a DynamoDB client that records deleted tables. Other calls panic.
+type deletedTable struct {
+	activeTable
+	deleted []string
+	waited  int
+}
+
+func (d *deletedTable) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	for _, name := range d.deleted {
+		if name == *input.TableName {
+			return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+		}
+	}
+	d.deleted = append(d.deleted, *input.TableName)
+	return &dynamodb.DeleteTableOutput{}, nil
+}
+
+func (d *deletedTable) WaitUntilTableNotExists(*dynamodb.DescribeTableInput) error {
+	d.waited++
+	return nil
+}
+
+func Test_DeleteTable(t *testing.T) {
+	table := &deletedTable{}
+	m := Mutex{DDBTableName: "Ephemeral", DDBSession: table}
+	assert.Nil(t, m.initialization())
+	assert.Nil(t, m.DeleteTable())
+	assert.Equal(t, []string{"Ephemeral"}, table.deleted)
+	assert.False(t, m.initialized) // Created again on the next use
+	assert.Nil(t, m.DeleteTable()) // Already gone
+	assert.Equal(t, 0, table.waited)
+	assert.Nil(t, m.DeleteTableAndWait())
+	assert.Equal(t, 1, table.waited)
+}
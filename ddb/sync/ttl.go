@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+const (
+	// ttlAttribute is the attribute holding the time at which DynamoDB may delete a lock item, if EnableTTL is set.
+	ttlAttribute = "ExpiresAt"
+
+	// defaultTTLRetention is the default of TTLRetention.
+	defaultTTLRetention = 24 * time.Hour
+)
+
+// enableTTL turns on DynamoDB Time to Live on the ExpiresAt attribute of the table, unless it is already on.
+func (m *Mutex) enableTTL() error {
+	described, err := m.DDBSession.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+		TableName: &m.DDBTableName,
+	})
+	if err != nil {
+		return fmt.Errorf("could not describe TTL: %w", err)
+	}
+	if ttl := described.TimeToLiveDescription; ttl != nil && ttl.TimeToLiveStatus != nil && ttl.AttributeName != nil {
+		switch *ttl.TimeToLiveStatus {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			if *ttl.AttributeName != ttlAttribute {
+				return fmt.Errorf("TTL of table %s is enabled on %s, not %s", m.DDBTableName, *ttl.AttributeName, ttlAttribute)
+			}
+			return nil
+		}
+	}
+	_, err = m.DDBSession.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: &m.DDBTableName,
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not enable TTL: %w", err)
+	}
+	return nil
+}
+
+// ttlExpiry returns the ExpiresAt attribute of a lock item written at lastWrite, in Unix nanoseconds. DynamoDB
+// requires it in seconds since the epoch.
+func (m *Mutex) ttlExpiry(lastWrite int64) *dynamodb.AttributeValue {
+	expiresAt := time.Unix(0, lastWrite).Add(m.TTLRetention).Unix()
+	return &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(expiresAt, 10)),
+	}
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// ttlTable is a DynamoDB client whose table has the given TTL description. It records UpdateTimeToLive calls.
+type ttlTable struct {
+	activeTable
+	description *dynamodb.TimeToLiveDescription
+	updates     []*dynamodb.UpdateTimeToLiveInput
+}
+
+func (t *ttlTable) DescribeTimeToLive(*dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: t.description}, nil
+}
+
+func (t *ttlTable) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	t.updates = append(t.updates, input)
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func Test_EnableTTL(t *testing.T) {
+	table := &ttlTable{description: &dynamodb.TimeToLiveDescription{
+		TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusDisabled),
+	}}
+	m := Mutex{EnableTTL: true, DDBSession: table}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, 24*time.Hour, m.TTLRetention)
+	assert.Len(t, table.updates, 1)
+	assert.Equal(t, ttlAttribute, *table.updates[0].TimeToLiveSpecification.AttributeName)
+	assert.True(t, *table.updates[0].TimeToLiveSpecification.Enabled)
+
+	table = &ttlTable{description: &dynamodb.TimeToLiveDescription{
+		AttributeName:    aws.String(ttlAttribute),
+		TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusEnabled),
+	}}
+	m = Mutex{EnableTTL: true, DDBSession: table}
+	assert.Nil(t, m.initialization())
+	assert.Empty(t, table.updates) // Already enabled
+
+	table.description.AttributeName = aws.String("DeleteAfter")
+	m = Mutex{EnableTTL: true, DDBSession: table}
+	assert.EqualError(t, m.initialization(), "TTL of table Locks is enabled on DeleteAfter, not ExpiresAt")
+}
+
+func Test_TTLExpiry(t *testing.T) {
+	m := Mutex{TTLRetention: time.Hour}
+	written := time.Date(2019, 10, 1, 12, 0, 0, 500, time.UTC)
+	assert.Equal(t, "1569934800", *m.ttlExpiry(written.UnixNano()).N) // Seconds, not nanoseconds
+}
@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"time"
+	"unicode/utf8"
+)
+
+// maxNameLength is the longest partition key value DynamoDB allows, in bytes.
+const maxNameLength = 2048
+
+// Validate checks the configuration of the Mutex and returns a descriptive error for the first invalid field or
+// combination of fields. It is called when the Mutex is initialized, so misconfigurations fail on first use instead
+// of with a confusing error of DynamoDB later. Defaults are not applied yet, so empty fields are valid.
+func (m *Mutex) Validate() error {
+	if !utf8.ValidString(m.Name) || len(m.Name) > maxNameLength {
+		return fmt.Errorf("invalid name %q: use at most %d bytes of valid UTF-8", m.Name, maxNameLength)
+	}
+	durations := []struct {
+		field    string
+		duration time.Duration
+	}{
+		{"timeout", m.timeout},
+		{"Expiry", m.Expiry},
+		{"ExpirySkewTolerance", m.ExpirySkewTolerance},
+		{"StaleGracePeriod", m.StaleGracePeriod},
+		{"ExpiryWarning", m.ExpiryWarning},
+		{"Cooldown", m.Cooldown},
+		{"InitialJitter", m.InitialJitter},
+		{"MaxBackoff", m.MaxBackoff},
+		{"RetryBackoff", m.RetryBackoff},
+		{"TablePollInterval", m.TablePollInterval},
+		{"TableReadyTimeout", m.TableReadyTimeout},
+		{"TTLRetention", m.TTLRetention},
+	}
+	for _, d := range durations {
+		if d.duration < 0 {
+			return fmt.Errorf("invalid %s: %v is negative", d.field, d.duration)
+		}
+	}
+	if m.ExpiryWarning > 0 && m.Expiry > 0 && m.ExpiryWarning >= m.Expiry {
+		return fmt.Errorf("invalid ExpiryWarning: %v is not shorter than Expiry %v", m.ExpiryWarning, m.Expiry)
+	}
+	if m.EnableTTL && m.Expiry > 0 {
+		retention := m.TTLRetention
+		if retention == 0 {
+			retention = defaultTTLRetention
+		}
+		if retention <= m.Expiry {
+			return fmt.Errorf("invalid TTLRetention: %v is not longer than Expiry %v, held locks would be deleted", retention, m.Expiry)
+		}
+	}
+	if m.MaxAttempts < 0 {
+		return fmt.Errorf("invalid MaxAttempts: %d is negative", m.MaxAttempts)
+	}
+	if m.ReadCapacityUnits < 0 || m.WriteCapacityUnits < 0 {
+		return errors.New("invalid capacity units: ReadCapacityUnits and WriteCapacityUnits must not be negative")
+	}
+	if m.BillingMode == dynamodb.BillingModePayPerRequest && (m.ReadCapacityUnits > 0 || m.WriteCapacityUnits > 0) {
+		return errors.New("invalid capacity units: on-demand tables with the PAY_PER_REQUEST billing mode have no provisioned capacity")
+	}
+	if m.KeyAttributeName != "" && m.KeyAttributeName != "Name" && m.DDBTableName == "" && m.TableNameTemplate == "" {
+		return fmt.Errorf("invalid key attribute name %q: set DDBTableName, the default table uses the key attribute Name", m.KeyAttributeName)
+	}
+	return nil
+}
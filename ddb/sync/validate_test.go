@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Validate(t *testing.T) {
+	assert.Nil(t, (&Mutex{}).Validate())
+	m := Mutex{Expiry: -time.Second}
+	assert.EqualError(t, m.Validate(), "invalid Expiry: -1s is negative")
+	m = Mutex{}.WithTimeout(-time.Second)
+	assert.EqualError(t, m.Validate(), "invalid timeout: -1s is negative")
+	m = Mutex{Name: "\xff"}
+	assert.EqualError(t, m.Validate(), `invalid name "\xff": use at most 2048 bytes of valid UTF-8`)
+	m = Mutex{Name: strings.Repeat("n", maxNameLength+1)}
+	assert.NotNil(t, m.Validate())
+	m = Mutex{Expiry: time.Second, ExpiryWarning: time.Second}
+	assert.EqualError(t, m.Validate(), "invalid ExpiryWarning: 1s is not shorter than Expiry 1s")
+	m = Mutex{EnableTTL: true, Expiry: time.Hour, TTLRetention: time.Minute}
+	assert.EqualError(t, m.Validate(), "invalid TTLRetention: 1m0s is not longer than Expiry 1h0m0s, held locks would be deleted")
+	m = Mutex{EnableTTL: true, Expiry: 48 * time.Hour}
+	assert.EqualError(t, m.Validate(), "invalid TTLRetention: 24h0m0s is not longer than Expiry 48h0m0s, held locks would be deleted")
+	m = Mutex{Expiry: time.Hour, TTLRetention: time.Minute}
+	assert.Nil(t, m.Validate()) // Without TTL, the retention does not matter
+	m = Mutex{MaxAttempts: -1}
+	assert.EqualError(t, m.Validate(), "invalid MaxAttempts: -1 is negative")
+	m = Mutex{BillingMode: "PAY_PER_REQUEST", ReadCapacityUnits: 10}
+	assert.EqualError(t, m.Validate(), "invalid capacity units: on-demand tables with the PAY_PER_REQUEST billing mode have no provisioned capacity")
+	m = Mutex{KeyAttributeName: "pk"}
+	assert.EqualError(t, m.Validate(), `invalid key attribute name "pk": set DDBTableName, the default table uses the key attribute Name`)
+	assert.NotNil(t, m.initialization()) // Before accessing DynamoDB
+}
+
+func Test_Validate_PayPerRequest(t *testing.T) {
+	m := Mutex{BillingMode: "PAY_PER_REQUEST", DDBSession: activeTable{}}
+	assert.Nil(t, m.initialization())
+	assert.Equal(t, int64(0), m.ReadCapacityUnits)
+	assert.Nil(t, m.configure()) // Configured again, without capacity defaults
+}
@@ -0,0 +1,44 @@
+package sync
+
+import "time"
+
+// startWatchdog starts the timer of ExpiryWarning for the lock that was just acquired.
+// Nothing is started if the Mutex does not expire or no warning is configured.
+func (m *Mutex) startWatchdog() {
+	m.stopWatchdog()
+	if m.Expiry <= 0 || m.ExpiryWarning <= 0 {
+		return
+	}
+	name, expiry, warning := m.Name, m.Expiry, m.ExpiryWarning
+	callback, logger := m.OnExpiryWarning, m.Logger
+	delay := expiry - warning
+	if delay < 0 {
+		delay = 0
+	}
+	m.watchdog = time.AfterFunc(delay, func() {
+		remaining := expiry - delay
+		if logger != nil {
+			logger.Printf("lock %s expires in %v and is still held", name, remaining)
+		}
+		if callback != nil {
+			callback(name, remaining)
+		}
+	})
+}
+
+// stopWatchdog stops the timer of ExpiryWarning, if it is running.
+func (m *Mutex) stopWatchdog() {
+	if m.watchdog != nil {
+		m.watchdog.Stop()
+		m.watchdog = nil
+	}
+}
+
+// released records that the lock is not held anymore.
+func (m *Mutex) released() {
+	m.locked = false
+	m.valueSet = false
+	m.stopWatchdog()
+	m.stopRenewal()
+	unregister(m)
+}
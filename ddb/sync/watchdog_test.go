@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_ExpiryWarning(t *testing.T) {
+	warnings := make(chan time.Duration, 1)
+	logger := &testLogger{}
+	m := Mutex{
+		Name:          "watched",
+		Expiry:        200 * time.Millisecond,
+		ExpiryWarning: 150 * time.Millisecond,
+		Logger:        logger,
+		OnExpiryWarning: func(name string, remaining time.Duration) {
+			assert.Equal(t, "watched", name)
+			warnings <- remaining
+		},
+	}
+	m.startWatchdog()
+	select {
+	case remaining := <-warnings:
+		assert.Equal(t, 150*time.Millisecond, remaining)
+	case <-time.After(time.Second):
+		t.Fatal("no expiry warning")
+	}
+	assert.Len(t, logger.lines, 1)
+
+	m.startWatchdog()
+	m.released()
+	select {
+	case <-warnings:
+		t.Fatal("expiry warning after release")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func Test_ExpiryWarningDisabled(t *testing.T) {
+	m := Mutex{Expiry: time.Second}
+	m.startWatchdog()
+	assert.Nil(t, m.watchdog)
+	m = Mutex{ExpiryWarning: time.Second}
+	m.startWatchdog()
+	assert.Nil(t, m.watchdog)
+}
@@ -3,9 +3,14 @@
 package dsync
 
 // A Locker represents an object that stores a value that can be locked and unlocked.
+//
+// TryLock attempts to lock once without blocking and reports whether it succeeded. TryUnlock unlocks,
+// returning an error instead of panicking like Unlock does.
 type Locker interface {
 	Lock()
 	Unlock()
+	TryLock() (bool, error)
+	TryUnlock() error
 	GetValueInt64() int64
 	GetValueUint64() uint64
 	SetValueInt64(value int64)
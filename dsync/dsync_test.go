@@ -1 +1,33 @@
 package dsync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_EncodeFields(t *testing.T) {
+	fields := map[string]string{
+		"leader": "host-1:8080",
+		"epoch":  "42",
+		"note":   "a=b&c d%",
+		"empty":  "",
+	}
+	encoded := EncodeFields(fields)
+	assert.Equal(t, "empty=&epoch=42&leader=host-1%3A8080&note=a%3Db%26c+d%25", encoded)
+	decoded, err := DecodeFields(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, fields, decoded)
+}
+
+func Test_DecodeFields_Empty(t *testing.T) {
+	decoded, err := DecodeFields(EncodeFields(nil))
+	assert.Nil(t, err)
+	assert.Empty(t, decoded)
+}
+
+func Test_DecodeFields_Invalid(t *testing.T) {
+	_, err := DecodeFields("a=%zz")
+	assert.NotNil(t, err)
+	_, err = DecodeFields("a=1&a=2")
+	assert.NotNil(t, err)
+}
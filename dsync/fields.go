@@ -0,0 +1,36 @@
+package dsync
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// EncodeFields packs a set of named fields into a single string that can be stored as the value of a Locker.
+//
+// The fields are URL-encoded and sorted by name, so the same fields always produce the same string.
+// Use DecodeFields to unpack the value.
+func EncodeFields(fields map[string]string) string {
+	values := url.Values{}
+	for name, value := range fields {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+// DecodeFields unpacks a string created by EncodeFields.
+//
+// It returns an error if the string is not a valid encoding, including if a field name occurs more than once.
+func DecodeFields(encoded string) (map[string]string, error) {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(values))
+	for name, value := range values {
+		if len(value) > 1 {
+			return nil, fmt.Errorf("field %q is set more than once", name)
+		}
+		fields[name] = value[0]
+	}
+	return fields, nil
+}
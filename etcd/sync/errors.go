@@ -0,0 +1,15 @@
+package sync
+
+import "errors"
+
+var (
+	// ErrAlreadyHeld is returned when locking a Mutex that is already locked by the same Mutex instance.
+	ErrAlreadyHeld = errors.New("lock is already held by this Mutex")
+
+	// ErrLockTimeout is returned when the lock could not be acquired before the timeout of the Mutex elapsed.
+	ErrLockTimeout = errors.New("could not lock mutex")
+
+	// ErrNotOwner is returned when unlocking a Mutex that does not hold its lock, including when the lock expired.
+	// The value of the Mutex is not written then.
+	ErrNotOwner = errors.New("lock is not held by this Mutex")
+)
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The Mutex talks to the JSON gateway of the etcd v3 API, which every etcd server serves next to gRPC, so the
+// package does not depend on the etcd client library. Keys and values are []byte, which encoding/json sends as
+// base64 like the gateway expects. The gateway sends int64 fields as strings.
+
+type keyValue struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type rangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type rangeResponse struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,omitempty,string"`
+}
+
+type deleteRangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type requestOp struct {
+	RequestPut         *putRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type compare struct {
+	Key            []byte `json:"key"`
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	CreateRevision string `json:"create_revision,omitempty"`
+	Value          []byte `json:"value,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+	Failure []requestOp `json:"failure"`
+}
+
+type txnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type leaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type leaseGrantResponse struct {
+	ID int64 `json:"ID,string"`
+}
+
+type leaseRevokeRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+// gatewayError is an error returned by the gateway.
+type gatewayError struct {
+	Status  int
+	Message string `json:"message"`
+}
+
+func (e *gatewayError) Error() string {
+	return fmt.Sprintf("etcd: %s (HTTP %d)", e.Message, e.Status)
+}
+
+// notExists compares the create revision of a key with 0, which holds if the key does not exist.
+func notExists(key []byte) compare {
+	return compare{Key: key, Result: "EQUAL", Target: "CREATE", CreateRevision: "0"}
+}
+
+// valueIs compares the value of a key with value. The compare targets are a oneof in the API, so only one of
+// CreateRevision and Value may be set.
+func valueIs(key []byte, value []byte) compare {
+	return compare{Key: key, Result: "EQUAL", Target: "VALUE", Value: value}
+}
+
+// call posts request to the given path of the gateway and decodes the reply into response. It waits for the reply
+// until the deadline of Lock, or for the timeout of the Mutex otherwise, so a stalled gateway cannot block forever.
+func (m *Mutex) call(path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	deadline := m.deadline
+	if deadline.IsZero() && m.timeout > 0 {
+		deadline = time.Now().Add(m.timeout)
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	post, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Endpoint+"/v3/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	post.Header.Set("Content-Type", "application/json")
+	reply, err := m.HTTPClient.Do(post)
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+	if reply.StatusCode != http.StatusOK {
+		failure := &gatewayError{Status: reply.StatusCode}
+		if err = json.NewDecoder(reply.Body).Decode(failure); err != nil || failure.Message == "" {
+			failure.Message = http.StatusText(reply.StatusCode)
+		}
+		return failure
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(reply.Body).Decode(response)
+}
@@ -0,0 +1,6 @@
+package sync
+
+import "github.com/greg-szabo/dsync/dsync"
+
+// Mutex is the etcd implementation of dsync.Locker, so code depending on the interface can switch backends.
+var _ dsync.Locker = (*Mutex)(nil)
@@ -0,0 +1,37 @@
+package sync
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random is the source of the jitter of the package. It is seeded once per process, instead of reseeding the
+// global source of math/rand on every initialization, which other packages may depend on.
+var (
+	randomOnce sync.Once
+	randomMu   sync.Mutex
+	random     *rand.Rand
+)
+
+// randomInt63n returns a random number in [0, n) for jitter, like rand.Int63n. It is safe for concurrent use.
+func randomInt63n(n int64) int64 {
+	randomOnce.Do(func() {
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	})
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Int63n(n)
+}
+
+// newToken returns a random token from crypto/rand to hold a lock with. Unlike a pseudo-random source seeded with
+// the time, it does not repeat for Mutexes locking at the same time, which would let both unlock the lock.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
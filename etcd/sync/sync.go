@@ -0,0 +1,335 @@
+// Package sync (etcd implementation) provides a distributed mutex that stores the semaphore in etcd together with a
+// value that can be shared across different processes. It mirrors the DynamoDB Mutex and implements dsync.Locker,
+// so code depending on the interface can switch backends by changing only how the Mutex is created.
+//
+// The lock is the key Name, created in a transaction only if it does not exist, and holding a random token of the
+// Mutex. With Expiry, the key is attached to a lease with that TTL, like the concurrency Mutex of the etcd client,
+// so the lock is deleted if its holder dies. The value is stored in the key "<Name>/value", so it outlives expired
+// locks. Unlock checks the token, writes the value and deletes the lock in a single transaction.
+//
+// Unlike the concurrency Mutex of the etcd client, waiters do not queue up by the revision of their keys and watch
+// the key before them: Lock retries the transaction after a random delay, like the other backends, so the lock is
+// not handed over in the order it was requested.
+package sync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay is the longest delay between two attempts to lock a Mutex.
+const maxRetryDelay = 100 * time.Millisecond
+
+// Mutex is a distributed lock with a value, stored in etcd.
+//
+// A Mutex should only be used by one goroutine at a time. Copy it before its first use to lock from several
+// goroutines, like the DynamoDB Mutex.
+type Mutex struct {
+	// Name of the lock. Default: Lock
+	Name string
+	// The value of the Mutex is set to this value, if it does not exist yet in etcd. Default: 0
+	DefaultValue string
+	// Time after which the lock expires, in case the holder dies. It is the TTL of the lease of the lock, rounded up
+	// to seconds. Default: 0, the lock never expires.
+	Expiry time.Duration
+
+	// URL of an etcd server, serving the JSON gateway of the v3 API. Default: http://localhost:2379
+	Endpoint string
+	// HTTP client used to call etcd, for example to set TLS client certificates. Each call is bounded by the timeout
+	// of the Mutex, on top of the timeouts of the client. Default: http.DefaultClient
+	HTTPClient *http.Client
+
+	initialized bool
+	timeout     time.Duration
+	timeoutSet  bool
+	locked      bool
+	token       string
+	lease       int64
+	value       string
+	valueRead   bool
+	deadline    time.Time
+}
+
+// initialization applies the defaults of the Mutex.
+func (m *Mutex) initialization() {
+	if m.initialized {
+		return
+	}
+	if m.Name == "" {
+		m.Name = "Lock"
+	}
+	if m.Endpoint == "" {
+		m.Endpoint = "http://localhost:2379"
+	}
+	if m.HTTPClient == nil {
+		m.HTTPClient = http.DefaultClient
+	}
+	if !m.timeoutSet {
+		m.timeout = 5 * time.Second
+	}
+	m.initialized = true
+}
+
+// WithTimeout defines a custom timeout value when trying to lock a key. It also bounds the wait for the replies of
+// etcd in TryLock and Unlock.
+//
+// Set it to 0 for no timeout.
+//
+// Default timeout value: 5 seconds
+func (m Mutex) WithTimeout(timeout time.Duration) Mutex {
+	m.timeout = timeout
+	m.timeoutSet = true
+	return m
+}
+
+// lockKey returns the key of the lock.
+func (m *Mutex) lockKey() []byte {
+	return []byte(m.Name)
+}
+
+// valueKey returns the key holding the value of the Mutex.
+func (m *Mutex) valueKey() []byte {
+	return []byte(m.Name + "/value")
+}
+
+// Lock locks the Mutex and retrieves its value from etcd. If the lock is already in use, the calling goroutine
+// blocks until the mutex is available or the timeout period has been reached. Errors cause a panic.
+//
+// It ignores previous locks if an expiry period has been set and they expired.
+func (m *Mutex) Lock() {
+	if err := m.lock(); err != nil {
+		panic(err)
+	}
+}
+
+// lock implements Lock, returning an error instead of panicking.
+func (m *Mutex) lock() error {
+	m.initialization()
+	started := time.Now()
+	if m.timeout > 0 {
+		m.deadline = started.Add(m.timeout)
+		defer func() { m.deadline = time.Time{} }()
+	}
+	for {
+		acquired, err := m.tryLock()
+		if errors.Is(err, context.DeadlineExceeded) && m.timeout > 0 && time.Since(started) >= m.timeout {
+			return ErrLockTimeout
+		}
+		if err != nil || acquired {
+			return err
+		}
+		remaining := time.Duration(math.MaxInt64)
+		if m.timeout > 0 {
+			remaining = m.timeout - time.Since(started)
+			if remaining <= 0 {
+				return ErrLockTimeout
+			}
+		}
+		delay := time.Duration(randomInt63n(int64(maxRetryDelay)))
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+	}
+}
+
+// TryLock makes a single attempt to lock the Mutex, without waiting. It returns false and no error if the lock is
+// held by someone else.
+func (m *Mutex) TryLock() (acquired bool, err error) {
+	m.initialization()
+	return m.tryLock()
+}
+
+// tryLock creates the lock key if it does not exist, and reads the value on success.
+func (m *Mutex) tryLock() (acquired bool, err error) {
+	if m.locked {
+		return false, ErrAlreadyHeld
+	}
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+
+	var lease int64
+	if m.Expiry > 0 {
+		grant := leaseGrantResponse{}
+		ttl := int64(math.Ceil(m.Expiry.Seconds()))
+		if err = m.call("lease/grant", leaseGrantRequest{TTL: ttl}, &grant); err != nil {
+			return
+		}
+		lease = grant.ID
+	}
+
+	txn := txnResponse{}
+	err = m.call("kv/txn", txnRequest{
+		Compare: []compare{notExists(m.lockKey())},
+		Success: []requestOp{{RequestPut: &putRequest{Key: m.lockKey(), Value: []byte(token), Lease: lease}}},
+	}, &txn)
+	if err != nil || !txn.Succeeded {
+		m.revoke(lease)
+		return
+	}
+	m.token = token
+	m.lease = lease
+	m.locked = true
+	m.valueRead = false
+	if err = m.readValue(); err != nil {
+		// The lock is held, but without its value. Release it, so it is not left behind until the lease expires, or
+		// forever without Expiry.
+		if releaseErr := m.release(); releaseErr == nil {
+			m.locked = false
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// readValue reads the value of the Mutex, setting it to DefaultValue if it does not exist yet.
+func (m *Mutex) readValue() (err error) {
+	defaultValue := m.DefaultValue
+	if defaultValue == "" {
+		defaultValue = "0"
+	}
+	err = m.call("kv/txn", txnRequest{
+		Compare: []compare{notExists(m.valueKey())},
+		Success: []requestOp{{RequestPut: &putRequest{Key: m.valueKey(), Value: []byte(defaultValue)}}},
+	}, nil)
+	if err != nil {
+		return
+	}
+	value := rangeResponse{}
+	err = m.call("kv/range", rangeRequest{Key: m.valueKey()}, &value)
+	if err != nil {
+		return
+	}
+	if len(value.Kvs) > 0 {
+		m.value = string(value.Kvs[0].Value)
+	}
+	m.valueRead = true
+	return
+}
+
+// release deletes the lock without writing the value and revokes its lease. It does not wait for the deadline of
+// Lock, which may have passed.
+func (m *Mutex) release() (err error) {
+	m.deadline = time.Time{}
+	err = m.call("kv/txn", txnRequest{
+		Compare: []compare{valueIs(m.lockKey(), []byte(m.token))},
+		Success: []requestOp{{RequestDeleteRange: &deleteRangeRequest{Key: m.lockKey()}}},
+	}, nil)
+	if err != nil {
+		return
+	}
+	m.revoke(m.lease)
+	m.lease = 0
+	return
+}
+
+// revoke revokes a lease, if one was granted. Failures are ignored, as the lease expires anyway.
+func (m *Mutex) revoke(lease int64) {
+	if lease != 0 {
+		_ = m.call("lease/revoke", leaseRevokeRequest{ID: lease}, nil)
+	}
+}
+
+// Unlock writes the value of the Mutex into etcd and unlocks it. Unlocking a Mutex that does not hold the lock
+// panics with ErrNotOwner.
+func (m *Mutex) Unlock() {
+	if err := m.TryUnlock(); err != nil {
+		panic(err)
+	}
+}
+
+// TryUnlock unlocks the Mutex like Unlock, but returns an error instead of panicking. It returns ErrNotOwner if the
+// Mutex does not hold the lock, including if the lock expired in the meantime; the value is not written then.
+//
+// If TryLock or Lock failed to read the value after taking the lock, and could not release it, the Mutex still
+// holds the lock: TryUnlock releases it without writing the value.
+func (m *Mutex) TryUnlock() (err error) {
+	if !m.locked {
+		return ErrNotOwner
+	}
+	m.initialization()
+	success := []requestOp{{RequestDeleteRange: &deleteRangeRequest{Key: m.lockKey()}}}
+	if m.valueRead {
+		success = append([]requestOp{{RequestPut: &putRequest{Key: m.valueKey(), Value: []byte(m.value)}}}, success...)
+	}
+	txn := txnResponse{}
+	err = m.call("kv/txn", txnRequest{
+		Compare: []compare{valueIs(m.lockKey(), []byte(m.token))},
+		Success: success,
+	}, &txn)
+	if err != nil {
+		return
+	}
+	m.locked = false
+	m.revoke(m.lease)
+	m.lease = 0
+	if !txn.Succeeded {
+		return ErrNotOwner
+	}
+	return
+}
+
+// LockAndGetValueString locks the Mutex and returns its value as a string.
+func (m *Mutex) LockAndGetValueString() string {
+	m.Lock()
+	return m.GetValueString()
+}
+
+// GetValueInt64 gets the value from the Mutex and returns it as an int64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueInt64() int64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseInt(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// GetValueUint64 gets the value from the Mutex and returns it as an uint64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueUint64() uint64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseUint(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SetValueInt64 sets the int64 value in the Mutex. It does not write the value into etcd. The value is written
+// during Unlock.
+func (m *Mutex) SetValueInt64(value int64) {
+	m.value = strconv.FormatInt(value, 10)
+}
+
+// SetValueUint64 sets the uint64 value in the Mutex. It does not write the value into etcd. The value is written
+// during Unlock.
+func (m *Mutex) SetValueUint64(value uint64) {
+	m.value = strconv.FormatUint(value, 10)
+}
+
+// GetValueString gets the value from the Mutex and returns it as a string.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueString() string {
+	return m.value
+}
+
+// SetValueString sets the string value in the Mutex. It does not write the value into etcd. The value is written
+// during Unlock.
+func (m *Mutex) SetValueString(value string) {
+	m.value = value
+}
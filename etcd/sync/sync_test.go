@@ -0,0 +1,237 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEtcd serves the parts of the JSON gateway of etcd that the Mutex uses, from memory.
+type fakeEtcd struct {
+	mu     sync.Mutex
+	kvs    map[string][]byte
+	leases map[int64]time.Time
+	leased map[string]int64
+	nextID int64
+}
+
+func newFakeEtcd() *httptest.Server {
+	return httptest.NewServer(newFakeStore())
+}
+
+func newFakeStore() *fakeEtcd {
+	return &fakeEtcd{
+		kvs:    make(map[string][]byte),
+		leases: make(map[int64]time.Time),
+		leased: make(map[string]int64),
+	}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, deadline := range f.leases {
+		if time.Now().After(deadline) {
+			f.revoke(id)
+		}
+	}
+	var response interface{}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		request := leaseGrantRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		f.nextID++
+		f.leases[f.nextID] = time.Now().Add(time.Duration(request.TTL) * time.Second)
+		response = leaseGrantResponse{ID: f.nextID}
+	case "/v3/lease/revoke":
+		request := leaseRevokeRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		f.revoke(request.ID)
+		response = struct{}{}
+	case "/v3/kv/range":
+		request := rangeRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		result := rangeResponse{}
+		if value, ok := f.kvs[string(request.Key)]; ok {
+			result.Kvs = []keyValue{{Key: request.Key, Value: value}}
+		}
+		response = result
+	case "/v3/kv/txn":
+		request := txnRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		succeeded := true
+		for _, c := range request.Compare {
+			value, exists := f.kvs[string(c.Key)]
+			switch c.Target {
+			case "CREATE":
+				succeeded = succeeded && !exists
+			case "VALUE":
+				succeeded = succeeded && exists && string(value) == string(c.Value)
+			}
+		}
+		ops := request.Failure
+		if succeeded {
+			ops = request.Success
+		}
+		for _, op := range ops {
+			if op.RequestPut != nil {
+				f.kvs[string(op.RequestPut.Key)] = op.RequestPut.Value
+				if op.RequestPut.Lease != 0 {
+					f.leased[string(op.RequestPut.Key)] = op.RequestPut.Lease
+				}
+			}
+			if op.RequestDeleteRange != nil {
+				delete(f.kvs, string(op.RequestDeleteRange.Key))
+				delete(f.leased, string(op.RequestDeleteRange.Key))
+			}
+		}
+		response = txnResponse{Succeeded: succeeded}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "unknown path"})
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// revoke deletes a lease and the keys attached to it.
+func (f *fakeEtcd) revoke(id int64) {
+	delete(f.leases, id)
+	for key, lease := range f.leased {
+		if lease == id {
+			delete(f.kvs, key)
+			delete(f.leased, key)
+		}
+	}
+}
+
+func Test_EtcdLock_Default(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	m := Mutex{Endpoint: server.URL}
+	assert.NotPanics(t, m.Lock)
+	assert.Equal(t, "0", m.GetValueString())
+	assert.Equal(t, ErrAlreadyHeld, m.lock())
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, ErrNotOwner, m.TryUnlock())
+}
+
+func Test_EtcdLock_CannotUnlockOthers(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	m := Mutex{Endpoint: server.URL}
+	n := Mutex{Endpoint: server.URL}.WithTimeout(200 * time.Millisecond)
+	assert.NotPanics(t, m.Lock)
+	assert.Panics(t, n.Lock)
+	acquired, err := n.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Panics(t, n.Unlock)
+	assert.NotPanics(t, m.Unlock)
+}
+
+func Test_EtcdLock_Expiry(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	expiry := time.Second
+	m := Mutex{Endpoint: server.URL, Expiry: expiry}
+	n := Mutex{Endpoint: server.URL, Expiry: expiry}
+	assert.NotPanics(t, m.Lock)
+	m.SetValueString("lost")
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock)
+	assert.Equal(t, ErrNotOwner, m.TryUnlock()) // Taken over, the value is not written
+	assert.Equal(t, "0", n.GetValueString())
+	assert.NotPanics(t, n.Unlock)
+}
+
+func Test_EtcdLock_ParallelCount(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	thisMany := 10
+	m := Mutex{Endpoint: server.URL, Expiry: 10 * time.Second}
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			m := Mutex{Endpoint: server.URL, Expiry: 10 * time.Second}
+			assert.NotPanics(t, m.Lock)
+			defer assert.NotPanics(t, m.Unlock)
+			i := m.GetValueInt64()
+			m.SetValueInt64(i + 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, strconv.Itoa(thisMany), m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+}
+
+func Test_EtcdLock_GatewayError(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	m := Mutex{Endpoint: server.URL + "/missing"}
+	_, err := m.TryLock()
+	assert.EqualError(t, err, "etcd: unknown path (HTTP 404)")
+}
+
+func Test_EtcdLock_ReleasesOnFailure(t *testing.T) {
+	f := newFakeStore()
+	failRange := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failRange) == 1 && r.URL.Path == "/v3/kv/range" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	m := Mutex{Endpoint: server.URL}
+	acquired, err := m.TryLock()
+	assert.False(t, acquired)
+	assert.EqualError(t, err, "etcd: Service Unavailable (HTTP 503)")
+	f.mu.Lock()
+	assert.NotContains(t, f.kvs, "Lock")
+	f.mu.Unlock()
+	assert.Equal(t, ErrNotOwner, m.TryUnlock())
+
+	n := Mutex{Endpoint: server.URL, Expiry: time.Minute}
+	acquired, err = n.TryLock()
+	assert.False(t, acquired)
+	assert.NotNil(t, err)
+	f.mu.Lock()
+	assert.NotContains(t, f.kvs, "Lock")
+	assert.Empty(t, f.leases)
+	f.mu.Unlock()
+
+	atomic.StoreInt32(&failRange, 0)
+	assert.NotPanics(t, m.Lock)
+	assert.NotPanics(t, m.Unlock)
+}
+
+func Test_EtcdLock_Deadline(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stalled)
+	m := Mutex{Endpoint: server.URL}.WithTimeout(100 * time.Millisecond)
+	started := time.Now()
+	assert.Equal(t, ErrLockTimeout, m.lock())
+	assert.True(t, time.Since(started) < time.Second)
+	acquired, err := m.TryLock()
+	assert.False(t, acquired)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
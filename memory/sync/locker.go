@@ -0,0 +1,6 @@
+package sync
+
+import "github.com/greg-szabo/dsync/dsync"
+
+// Mutex is the in-memory implementation of dsync.Locker, so it can stand in for the DynamoDB Mutex in tests.
+var _ dsync.Locker = (*Mutex)(nil)
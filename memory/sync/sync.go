@@ -0,0 +1,212 @@
+// Package sync (in-memory implementation) provides a mutex with the semantics of the DynamoDB Mutex, whose locks and
+// values are kept in the memory of the process. It implements dsync.Locker, so tests of code that depends on the
+// interface can run without DynamoDB.
+//
+// Mutexes with the same Name share the same lock and value, like processes sharing a lock item. Locks block and
+// time out like the DynamoDB Mutex does, so tests exercise the same contention across goroutines.
+package sync
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lock is the shared state of the Mutexes with the same name.
+type lock struct {
+	// held has room for one token, which is in it while the lock is held
+	held  chan struct{}
+	value *string
+}
+
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*lock)
+)
+
+// Mutex is a lock with a value, shared by all Mutexes of the process with the same Name.
+// A Mutex instance should only be used by one goroutine at a time, like the DynamoDB Mutex.
+type Mutex struct {
+	// Name of the lock. Default: Lock
+	Name string
+	// The value of the Mutex is set to this value, if it does not exist yet. Default: 0
+	DefaultValue string
+
+	timeout    time.Duration
+	timeoutSet bool
+	locked     bool
+	value      string
+}
+
+// Reset forgets all locks and values. Call it between tests, when no Mutex is locked.
+func Reset() {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	locks = make(map[string]*lock)
+}
+
+// shared returns the state of the lock of the Mutex, creating it on the first call.
+func (m *Mutex) shared() *lock {
+	if m.Name == "" {
+		m.Name = "Lock"
+	}
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l, ok := locks[m.Name]
+	if !ok {
+		l = &lock{held: make(chan struct{}, 1)}
+		locks[m.Name] = l
+	}
+	return l
+}
+
+// WithTimeout defines a custom timeout value when trying to lock a key.
+//
+// Set it to 0 for no timeout.
+//
+// Default timeout value: 5 seconds
+func (m Mutex) WithTimeout(timeout time.Duration) Mutex {
+	m.timeout = timeout
+	m.timeoutSet = true
+	return m
+}
+
+// Lock locks the Mutex and retrieves its value. If the lock is already in use, the calling goroutine blocks until
+// the mutex is available or the timeout period has been reached. Errors cause a panic.
+func (m *Mutex) Lock() {
+	if err := m.lock(); err != nil {
+		panic(err)
+	}
+}
+
+// lock implements Lock, returning an error instead of panicking.
+func (m *Mutex) lock() error {
+	if m.locked {
+		return ErrAlreadyHeld
+	}
+	if !m.timeoutSet {
+		m.timeout = 5 * time.Second
+	}
+	l := m.shared()
+	if m.timeout <= 0 {
+		l.held <- struct{}{}
+		m.acquired(l)
+		return nil
+	}
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case l.held <- struct{}{}:
+		m.acquired(l)
+		return nil
+	case <-timer.C:
+		return ErrLockTimeout
+	}
+}
+
+// TryLock makes a single attempt to lock the Mutex, without waiting. It returns false and no error if the lock is
+// held by someone else.
+func (m *Mutex) TryLock() (acquired bool, err error) {
+	if m.locked {
+		return false, ErrAlreadyHeld
+	}
+	l := m.shared()
+	select {
+	case l.held <- struct{}{}:
+		m.acquired(l)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// acquired records that the Mutex holds the lock and reads the shared value, setting it to DefaultValue if it does
+// not exist yet. The lock must be held.
+func (m *Mutex) acquired(l *lock) {
+	m.locked = true
+	if l.value == nil {
+		defaultValue := m.DefaultValue
+		if defaultValue == "" {
+			defaultValue = "0"
+		}
+		l.value = &defaultValue
+	}
+	m.value = *l.value
+}
+
+// Unlock writes the value of the Mutex into the shared state and unlocks it. Unlocking a Mutex that does not hold
+// the lock panics with ErrNotOwner.
+func (m *Mutex) Unlock() {
+	if err := m.TryUnlock(); err != nil {
+		panic(err)
+	}
+}
+
+// TryUnlock unlocks the Mutex like Unlock, but returns an error instead of panicking.
+func (m *Mutex) TryUnlock() error {
+	if !m.locked {
+		return ErrNotOwner
+	}
+	l := m.shared()
+	value := m.value
+	l.value = &value
+	m.locked = false
+	<-l.held
+	return nil
+}
+
+// LockAndGetValueString locks the Mutex and returns its value as a string.
+func (m *Mutex) LockAndGetValueString() string {
+	m.Lock()
+	return m.GetValueString()
+}
+
+// GetValueInt64 gets the value from the Mutex and returns it as an int64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueInt64() int64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseInt(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// GetValueUint64 gets the value from the Mutex and returns it as an uint64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueUint64() uint64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseUint(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SetValueInt64 sets the int64 value in the Mutex. It is shared with the other Mutexes during Unlock.
+func (m *Mutex) SetValueInt64(value int64) {
+	m.value = strconv.FormatInt(value, 10)
+}
+
+// SetValueUint64 sets the uint64 value in the Mutex. It is shared with the other Mutexes during Unlock.
+func (m *Mutex) SetValueUint64(value uint64) {
+	m.value = strconv.FormatUint(value, 10)
+}
+
+// GetValueString gets the value from the Mutex and returns it as a string.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueString() string {
+	return m.value
+}
+
+// SetValueString sets the string value in the Mutex. It is shared with the other Mutexes during Unlock.
+func (m *Mutex) SetValueString(value string) {
+	m.value = value
+}
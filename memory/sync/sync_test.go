@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Lock_Default(t *testing.T) {
+	m := Mutex{}
+	assert.NotPanics(t, m.Lock)
+	assert.Equal(t, ErrAlreadyHeld, m.lock())
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, ErrNotOwner, m.TryUnlock())
+	Reset()
+}
+
+func Test_DefaultValue(t *testing.T) {
+	m := Mutex{Name: "default"}
+	assert.Equal(t, "0", m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	n := Mutex{Name: "custom", DefaultValue: "start"}
+	assert.Equal(t, "start", n.LockAndGetValueString())
+	n.SetValueString("changed")
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, "changed", n.LockAndGetValueString())
+	assert.NotPanics(t, n.Unlock)
+	Reset()
+}
+
+func Test_Lock_Timeout(t *testing.T) {
+	m := Mutex{Name: "timeout"}
+	n := Mutex{Name: "timeout"}.WithTimeout(50 * time.Millisecond)
+	other := Mutex{Name: "other"}
+	assert.NotPanics(t, m.Lock)
+	started := time.Now()
+	assert.Equal(t, ErrLockTimeout, n.lock())
+	assert.True(t, time.Since(started) >= 50*time.Millisecond)
+	assert.Panics(t, n.Unlock) // Cannot unlock others
+	acquired, err := n.TryLock()
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.NotPanics(t, other.Lock) // Other names are independent
+	assert.NotPanics(t, m.Unlock)
+	acquired, err = n.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.NotPanics(t, n.Unlock)
+	assert.NotPanics(t, other.Unlock)
+	Reset()
+}
+
+func Test_ParallelCount(t *testing.T) {
+	thisMany := 100
+	m := Mutex{Name: "parallel"}
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			m := Mutex{Name: "parallel"}
+			assert.NotPanics(t, m.Lock)
+			defer assert.NotPanics(t, m.Unlock)
+			i := m.GetValueInt64()
+			m.SetValueInt64(i + 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, strconv.Itoa(thisMany), m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+	Reset()
+}
+
+func Test_Values(t *testing.T) {
+	m := Mutex{Name: "values"}
+	n := Mutex{Name: "values"}
+	assert.NotPanics(t, m.Lock)
+	m.SetValueUint64(18446744073709551615)
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, uint64(0), n.GetValueUint64()) // Not locked yet
+	assert.NotPanics(t, n.Lock)
+	assert.Equal(t, uint64(18446744073709551615), n.GetValueUint64())
+	n.SetValueInt64(-5)
+	assert.NotPanics(t, n.Unlock)
+	assert.Equal(t, "-5", m.LockAndGetValueString())
+	assert.Equal(t, int64(-5), m.GetValueInt64())
+	assert.NotPanics(t, m.Unlock)
+	Reset()
+}
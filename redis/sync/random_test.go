@@ -0,0 +1,15 @@
+package sync
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_NewToken(t *testing.T) {
+	a, err := newToken()
+	assert.Nil(t, err)
+	b, err := newToken()
+	assert.Nil(t, err)
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
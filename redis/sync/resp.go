@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// redisError is an error reply of the Redis server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// conn is a connection to a Redis server speaking RESP, the Redis serialization protocol. It only supports what the
+// Mutex needs, so the package does not depend on a Redis client library.
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+	}
+}
+
+// do sends a command and returns its reply: a string, an int64, nil or a []interface{} of those.
+// Error replies are returned as a redisError.
+func (c *conn) do(args ...string) (interface{}, error) {
+	command := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		command = append(command, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := c.Write(command); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads a single reply from the server.
+func (c *conn) reply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid reply: %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(payload)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		bulk := make([]byte, length+2)
+		if _, err = io.ReadFull(c.reader, bulk); err != nil {
+			return nil, err
+		}
+		return string(bulk[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(payload)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, length)
+		for i := range array {
+			array[i], err = c.reply()
+			if err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				array[i] = replyErr
+			}
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("invalid reply: %q", line)
+}
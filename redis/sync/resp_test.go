@@ -0,0 +1,164 @@
+package sync
+
+import (
+	"bufio"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer answers each command read from c with the next of replies, and records the commands it read.
+func fakeServer(c net.Conn, replies ...string) chan string {
+	commands := make(chan string, len(replies))
+	go func() {
+		reader := bufio.NewReader(c)
+		for _, reply := range replies {
+			buffer := make([]byte, 4096)
+			n, err := reader.Read(buffer)
+			if err != nil {
+				return
+			}
+			commands <- string(buffer[:n])
+			if _, err = c.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+		close(commands)
+	}()
+	return commands
+}
+
+func Test_Conn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	commands := fakeServer(server,
+		"+OK\r\n",
+		"$-1\r\n",
+		"$5\r\nhello\r\n",
+		":1\r\n",
+		"-ERR unknown command\r\n",
+		"*3\r\n$1\r\na\r\n:2\r\n-ERR nested\r\n",
+	)
+	c := newConn(client)
+
+	reply, err := c.do("SET", "lock", "token", "NX")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+	assert.Equal(t, "*4\r\n$3\r\nSET\r\n$4\r\nlock\r\n$5\r\ntoken\r\n$2\r\nNX\r\n", <-commands)
+
+	reply, err = c.do("GET", "missing")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+	<-commands
+
+	reply, err = c.do("GET", "greeting")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", reply)
+	<-commands
+
+	reply, err = c.do("DEL", "lock")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), reply)
+	<-commands
+
+	_, err = c.do("NOPE")
+	assert.Equal(t, redisError("ERR unknown command"), err)
+	<-commands
+
+	reply, err = c.do("MULTI")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", int64(2), redisError("ERR nested")}, reply)
+}
+
+func Test_Conn_InvalidReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	fakeServer(server, "?what\r\n")
+	_, err := newConn(client).do("PING")
+	assert.NotNil(t, err)
+}
+
+func Test_Mutex_Deadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() { // A hung server reads commands, but never replies
+		buffer := make([]byte, 4096)
+		for {
+			if _, err := server.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+	m := Mutex{Name: "hung", conn: newConn(client), locked: true}.WithTimeout(50 * time.Millisecond)
+	started := time.Now()
+	err := m.TryUnlock()
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout())
+	assert.True(t, time.Since(started) < time.Second)
+	assert.Nil(t, m.conn) // Closed, so the late reply is not taken for the next one
+}
+
+func Test_Mutex_ErrorReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	fakeServer(server, "-ERR wrong\r\n", "?what\r\n")
+	m := Mutex{Name: "errors", conn: newConn(client)}
+	_, err := m.do("PING")
+	assert.Equal(t, redisError("ERR wrong"), err)
+	assert.NotNil(t, m.conn) // Error replies keep the connection in sync
+	_, err = m.do("PING")
+	assert.NotNil(t, err)
+	assert.Nil(t, m.conn)
+}
+
+// fakeRedis listens on a local port and answers the commands of each accepted connection with the next replies of
+// conns, like fakeServer. Commands of all connections are sent to the returned channel.
+func fakeRedis(t *testing.T, conns ...[]string) (address string, commands chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	commands = make(chan string, 16)
+	go func() {
+		defer listener.Close()
+		for _, replies := range conns {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			for command := range fakeServer(c, replies...) {
+				commands <- command
+			}
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func Test_Mutex_TryLock_ReleasesOnFailure(t *testing.T) {
+	address, commands := fakeRedis(t,
+		[]string{"+OK\r\n", "?broken\r\n"}, // The value cannot be read after taking the lock
+		[]string{":1\r\n"},
+	)
+	m := Mutex{Name: "orphan", Address: address}
+	acquired, err := m.TryLock()
+	assert.NotNil(t, err)
+	assert.False(t, acquired)
+	assert.Contains(t, <-commands, "NX")
+	<-commands
+	release := <-commands
+	assert.Contains(t, release, releaseScript)
+	assert.Contains(t, release, m.token)
+	assert.False(t, m.locked)
+	assert.Nil(t, m.Close())
+}
+
+func Test_Mutex_TryUnlock_WithoutValue(t *testing.T) {
+	address, commands := fakeRedis(t, []string{":1\r\n"})
+	m := Mutex{Name: "orphan", Address: address, locked: true, token: "token"}
+	m.SetValueString("stale")
+	assert.Nil(t, m.TryUnlock())
+	release := <-commands
+	assert.Contains(t, release, releaseScript)
+	assert.NotContains(t, release, "stale") // The value was not read, so it is not written
+	assert.Nil(t, m.Close())
+}
@@ -0,0 +1,359 @@
+// Package sync (Redis implementation) provides a distributed mutex that stores the semaphore in Redis together with a
+// value that can be shared across different processes. It mirrors the DynamoDB Mutex and implements dsync.Locker,
+// so code depending on the interface can switch backends.
+//
+// The lock is a key set with SET NX, holding a random token of the Mutex, and expiring after Expiry if that is set.
+// The value is stored in a separate key, "<Name>:value", so it outlives expired locks. Unlock checks the token and
+// writes the value in a single Lua script, so a Mutex whose lock expired and was taken over cannot unlock it or
+// overwrite its value.
+package sync
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay is the longest delay between two attempts to lock a Mutex.
+const maxRetryDelay = 100 * time.Millisecond
+
+// unlockScript deletes the lock and writes the value if the lock still holds the token of the Mutex.
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[2], ARGV[2])
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// releaseScript deletes the lock if it still holds the token of the Mutex, without writing the value.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// Mutex is a distributed lock with a value, stored in Redis.
+//
+// A Mutex connects to Redis on its first use and should only be used by one goroutine at a time. Copy it before
+// its first use to lock from several goroutines, like the DynamoDB Mutex.
+type Mutex struct {
+	// Name of the lock. Default: Lock
+	Name string
+	// The value of the Mutex is set to this value, if it does not exist yet in Redis. Default: 0
+	DefaultValue string
+	// Time after which the lock expires and can be locked by others, in case the holder dies. Redis rounds it to
+	// milliseconds. Default: 0, the lock never expires.
+	Expiry time.Duration
+
+	// Address of the Redis server, as host:port. Default: localhost:6379
+	Address string
+	// Password of the Redis server, sent with AUTH if set.
+	Password string
+	// Number of the Redis database, selected with SELECT if it is not 0.
+	DB int
+
+	conn       *conn
+	deadline   time.Time
+	timeout    time.Duration
+	timeoutSet bool
+	locked     bool
+	token      string
+	value      string
+	valueRead  bool
+}
+
+// initialization applies the defaults and connects to Redis, if the Mutex is not connected yet.
+func (m *Mutex) initialization() (err error) {
+	if m.conn != nil {
+		return
+	}
+	if m.Name == "" {
+		m.Name = "Lock"
+	}
+	if m.Address == "" {
+		m.Address = "localhost:6379"
+	}
+	if !m.timeoutSet {
+		m.timeout = 5 * time.Second
+	}
+	c, err := net.DialTimeout("tcp", m.Address, m.timeout)
+	if err != nil {
+		return
+	}
+	m.conn = newConn(c)
+	if m.Password != "" {
+		if _, err = m.do("AUTH", m.Password); err != nil {
+			m.Close()
+			return
+		}
+	}
+	if m.DB != 0 {
+		if _, err = m.do("SELECT", strconv.Itoa(m.DB)); err != nil {
+			m.Close()
+			return
+		}
+	}
+	return
+}
+
+// Close closes the connection of the Mutex to Redis. It does not unlock the Mutex. The Mutex reconnects if it is
+// used again.
+func (m *Mutex) Close() (err error) {
+	if m.conn == nil {
+		return
+	}
+	err = m.conn.Close()
+	m.conn = nil
+	return
+}
+
+// do sends a command to Redis, waiting for the reply until the deadline of Lock, or for the timeout of the Mutex
+// otherwise. The connection is closed if the command fails for any other reason than an error reply, like a
+// timeout, as a late reply would be taken for the reply of the next command. The Mutex reconnects on its next use.
+func (m *Mutex) do(args ...string) (reply interface{}, err error) {
+	deadline := m.deadline
+	if deadline.IsZero() && m.timeout > 0 {
+		deadline = time.Now().Add(m.timeout)
+	}
+	err = m.conn.SetDeadline(deadline)
+	if err == nil {
+		reply, err = m.conn.do(args...)
+	}
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		m.Close()
+	}
+	return
+}
+
+// WithTimeout defines a custom timeout value when trying to lock a key. It also bounds the wait for the replies of
+// Redis in TryLock and Unlock, and connecting to Redis.
+//
+// Set it to 0 for no timeout.
+//
+// Default timeout value: 5 seconds
+func (m Mutex) WithTimeout(timeout time.Duration) Mutex {
+	m.timeout = timeout
+	m.timeoutSet = true
+	return m
+}
+
+// valueKey returns the key holding the value of the Mutex.
+func (m *Mutex) valueKey() string {
+	return m.Name + ":value"
+}
+
+// Lock locks the Mutex and retrieves its value from Redis. If the lock is already in use, the calling goroutine
+// blocks until the mutex is available or the timeout period has been reached. Errors cause a panic.
+//
+// It ignores previous locks if an expiry period has been set and they expired.
+func (m *Mutex) Lock() {
+	if err := m.lock(); err != nil {
+		panic(err)
+	}
+}
+
+// lock implements Lock, returning an error instead of panicking.
+func (m *Mutex) lock() (err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	started := time.Now()
+	if m.timeout > 0 {
+		m.deadline = started.Add(m.timeout)
+		defer func() { m.deadline = time.Time{} }()
+	}
+	for {
+		acquired, err := m.tryLock()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && m.timeout > 0 && time.Since(started) >= m.timeout {
+			return ErrLockTimeout
+		}
+		if err != nil || acquired {
+			return err
+		}
+		remaining := time.Duration(math.MaxInt64)
+		if m.timeout > 0 {
+			remaining = m.timeout - time.Since(started)
+			if remaining <= 0 {
+				return ErrLockTimeout
+			}
+		}
+		delay := time.Duration(randomInt63n(int64(maxRetryDelay)))
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+	}
+}
+
+// TryLock makes a single attempt to lock the Mutex, without waiting. It returns false and no error if the lock is
+// held by someone else.
+func (m *Mutex) TryLock() (acquired bool, err error) {
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	return m.tryLock()
+}
+
+// tryLock sets the lock key if it does not exist, and reads the value on success.
+func (m *Mutex) tryLock() (acquired bool, err error) {
+	if m.locked {
+		return false, ErrAlreadyHeld
+	}
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	args := []string{"SET", m.Name, token, "NX"}
+	if m.Expiry > 0 {
+		expiry := m.Expiry.Milliseconds()
+		if expiry < 1 {
+			expiry = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(expiry, 10))
+	}
+	reply, err := m.do(args...)
+	if err != nil || reply == nil {
+		return
+	}
+	m.token = token
+	m.locked = true
+	m.valueRead = false
+	if err = m.readValue(); err != nil {
+		// The lock is held, but without its value. Release it, so it is not left behind until it expires, or
+		// forever without Expiry.
+		if releaseErr := m.release(); releaseErr == nil {
+			m.locked = false
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// readValue reads the value of the Mutex, setting it to DefaultValue if it does not exist yet.
+func (m *Mutex) readValue() (err error) {
+	defaultValue := m.DefaultValue
+	if defaultValue == "" {
+		defaultValue = "0"
+	}
+	if _, err = m.do("SET", m.valueKey(), defaultValue, "NX"); err != nil {
+		return
+	}
+	value, err := m.do("GET", m.valueKey())
+	if err != nil {
+		return
+	}
+	m.value, _ = value.(string)
+	m.valueRead = true
+	return
+}
+
+// release deletes the lock without writing the value, reconnecting if the connection was dropped. It does not wait
+// for the deadline of Lock, which may have passed.
+func (m *Mutex) release() (err error) {
+	m.deadline = time.Time{}
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	_, err = m.do("EVAL", releaseScript, "1", m.Name, m.token)
+	return
+}
+
+// Unlock writes the value of the Mutex into Redis and unlocks it. Unlocking a Mutex that does not hold the lock
+// panics with ErrNotOwner.
+func (m *Mutex) Unlock() {
+	if err := m.TryUnlock(); err != nil {
+		panic(err)
+	}
+}
+
+// TryUnlock unlocks the Mutex like Unlock, but returns an error instead of panicking. It returns ErrNotOwner if the
+// Mutex does not hold the lock, including if the lock expired in the meantime; the value is not written then.
+//
+// If TryLock or Lock failed to read the value after taking the lock, and could not release it, the Mutex still
+// holds the lock: TryUnlock releases it without writing the value.
+func (m *Mutex) TryUnlock() (err error) {
+	if !m.locked {
+		return ErrNotOwner
+	}
+	err = m.initialization()
+	if err != nil {
+		return
+	}
+	script, args := unlockScript, []string{"2", m.Name, m.valueKey(), m.token, m.value}
+	if !m.valueRead {
+		script, args = releaseScript, []string{"1", m.Name, m.token}
+	}
+	reply, err := m.do(append([]string{"EVAL", script}, args...)...)
+	if err != nil {
+		return
+	}
+	m.locked = false
+	if deleted, _ := reply.(int64); deleted != 1 {
+		return ErrNotOwner
+	}
+	return
+}
+
+// LockAndGetValueString locks the Mutex and returns its value as a string.
+func (m *Mutex) LockAndGetValueString() string {
+	m.Lock()
+	return m.GetValueString()
+}
+
+// GetValueInt64 gets the value from the Mutex and returns it as an int64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueInt64() int64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseInt(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// GetValueUint64 gets the value from the Mutex and returns it as an uint64. An empty value is 0.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueUint64() uint64 {
+	if m.value == "" {
+		return 0
+	}
+	result, err := strconv.ParseUint(m.value, 10, 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SetValueInt64 sets the int64 value in the Mutex. It does not write the value into Redis. The value is written
+// during Unlock.
+func (m *Mutex) SetValueInt64(value int64) {
+	m.value = strconv.FormatInt(value, 10)
+}
+
+// SetValueUint64 sets the uint64 value in the Mutex. It does not write the value into Redis. The value is written
+// during Unlock.
+func (m *Mutex) SetValueUint64(value uint64) {
+	m.value = strconv.FormatUint(value, 10)
+}
+
+// GetValueString gets the value from the Mutex and returns it as a string.
+//
+// It does not check if the Mutex was locked beforehand. An unlocked Mutex will return an out-of-sync result.
+func (m *Mutex) GetValueString() string {
+	return m.value
+}
+
+// SetValueString sets the string value in the Mutex. It does not write the value into Redis. The value is written
+// during Unlock.
+func (m *Mutex) SetValueString(value string) {
+	m.value = value
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests below need a Redis server at localhost:6379.
+
+func Test_RedisLock_Default(t *testing.T) {
+	m := Mutex{Name: fmt.Sprintf("Test-Default-%d", time.Now().UnixNano())}
+	assert.NotPanics(t, m.Lock)
+	assert.Equal(t, ErrAlreadyHeld, m.lock())
+	assert.NotPanics(t, m.Unlock)
+	assert.Equal(t, ErrNotOwner, m.TryUnlock())
+	assert.Nil(t, m.Close())
+}
+
+func Test_RedisLock_CannotUnlockOthers(t *testing.T) {
+	Name := fmt.Sprintf("Test-CannotUnlock-%d", time.Now().UnixNano())
+	m := Mutex{Name: Name}
+	n := Mutex{Name: Name}.WithTimeout(time.Second)
+	assert.NotPanics(t, m.Lock)
+	assert.Panics(t, n.Lock)
+	assert.Panics(t, n.Unlock)
+	assert.NotPanics(t, m.Unlock)
+}
+
+func Test_RedisLock_Expiry(t *testing.T) {
+	expiry := time.Second
+	Name := fmt.Sprintf("Test-Expiry-%d", time.Now().UnixNano())
+	m := Mutex{Name: Name, Expiry: expiry}
+	n := Mutex{Name: Name, Expiry: expiry}
+	assert.NotPanics(t, m.Lock)
+	m.SetValueString("lost")
+	time.Sleep(expiry)
+	assert.NotPanics(t, n.Lock)
+	assert.Equal(t, ErrNotOwner, m.TryUnlock()) // Taken over, the value is not written
+	assert.Equal(t, "0", n.GetValueString())
+	assert.NotPanics(t, n.Unlock)
+}
+
+func Test_RedisLock_ParallelCount(t *testing.T) {
+	Name := fmt.Sprintf("Test-Parallel-%d", time.Now().UnixNano())
+	thisMany := 10
+	m := Mutex{Name: Name}
+	wg := sync.WaitGroup{}
+	wg.Add(thisMany)
+	for i := 0; i < thisMany; i++ {
+		go func() {
+			defer wg.Done()
+			m := Mutex{Name: Name}
+			defer m.Close()
+			assert.NotPanics(t, m.Lock)
+			defer assert.NotPanics(t, m.Unlock)
+			i := m.GetValueInt64()
+			m.SetValueInt64(i + 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, strconv.Itoa(thisMany), m.LockAndGetValueString())
+	assert.NotPanics(t, m.Unlock)
+}